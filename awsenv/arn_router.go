@@ -0,0 +1,167 @@
+package awsenv
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+// GetterFactory returns a ParamsGetter for Parameter Store in the given
+// partition and region, acting on parameters shared from the given account.
+// An empty account is the caller's own.
+type GetterFactory func(partition, region, account string) (ParamsGetter, error)
+
+// ARNRouter is a ParamsGetter that sends SSM parameter ARNs to a
+// ParamsGetter for the partition, region and account named in the ARN, and
+// all other names to a default ParamsGetter. Values fetched by ARN are
+// returned keyed by the ARN, so the same path in different regions or
+// accounts does not collide. ARNs without an account, e.g.
+// `arn:aws:ssm:us-west-2::parameter/prod/db`, are sent as plain paths.
+type ARNRouter struct {
+	fallback ParamsGetter
+	factory  GetterFactory
+
+	mu      sync.Mutex
+	getters map[arnTarget]ParamsGetter
+}
+
+// arnTarget identifies the partition, region and account of a parameter
+// ARN. It is the zero value for names that are not ARNs.
+type arnTarget struct {
+	partition string
+	region    string
+	account   string
+}
+
+// NewARNRouter returns an ARNRouter that sends names which are not
+// parameter ARNs to fallback, and creates the ParamsGetter for each
+// partition, region and account using factory, the first time it is needed. If fallback is
+// nil, names which are not ARNs will result in an error.
+func NewARNRouter(fallback ParamsGetter, factory GetterFactory) *ARNRouter {
+	return &ARNRouter{
+		fallback: fallback,
+		factory:  factory,
+		getters:  make(map[arnTarget]ParamsGetter),
+	}
+}
+
+// GetParams groups names by the partition, region and account in their ARN
+// and fetches each group from its ParamsGetter, respecting any limit it has
+// on the number of names per request. Invalid names reported by any
+// ParamsGetter are returned together in an *InvalidParamsError.
+func (r *ARNRouter) GetParams(ctx context.Context, names []string) (map[string]string, error) {
+	groups := make(map[arnTarget][]string)
+	for _, name := range names {
+		var target arnTarget
+		target.partition, target.region, target.account, _ = parseSSMARN(name)
+		groups[target] = append(groups[target], name)
+	}
+
+	getters := make(map[arnTarget]ParamsGetter, len(groups))
+	for target, group := range groups {
+		getter, err := r.getter(target)
+		if err != nil {
+			return nil, err
+		}
+		if getter == nil {
+			return nil, errors.Errorf("awsenv: no backend for param: %q", group[0])
+		}
+		getters[target] = getter
+	}
+
+	eg, ctx := errgroup.WithContext(ctx)
+
+	var (
+		mu      sync.Mutex
+		invalid []string
+	)
+	dest := make(map[string]string, len(names))
+
+	for target, group := range groups {
+		// copied to avoid race condition
+		target, group := target, group
+		getter := getters[target]
+
+		req := group
+		if target != (arnTarget{}) && target.account == "" {
+			req = make([]string, len(group))
+			for i, name := range group {
+				req[i] = stripARNPrefix(name)
+			}
+		}
+
+		eg.Go(func() error {
+			vals, err := fetch(ctx, getter, req)
+			groupInvalid, err := invalidNames(err)
+			if err != nil {
+				return err
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if target == (arnTarget{}) {
+				merge(dest, []map[string]string{vals})
+				for name := range groupInvalid {
+					invalid = append(invalid, name)
+				}
+				return nil
+			}
+
+			// Parameter Store returns values keyed by plain path, even when
+			// asked for an ARN, so re-key them by the ARN requested.
+			for _, name := range group {
+				plain := stripARNPrefix(name)
+
+				val, ok := vals[name]
+				if !ok {
+					val, ok = vals[plain]
+				}
+				if ok {
+					dest[name] = val
+				}
+
+				if groupInvalid[name] || groupInvalid[plain] {
+					invalid = append(invalid, name)
+				}
+			}
+			return nil
+		})
+	}
+
+	err := eg.Wait()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(invalid) > 0 {
+		return dest, &InvalidParamsError{Names: invalid}
+	}
+
+	return dest, nil
+}
+
+// getter returns the ParamsGetter for target, creating it if this is the
+// first time it is needed.
+func (r *ARNRouter) getter(target arnTarget) (ParamsGetter, error) {
+	if target == (arnTarget{}) {
+		return r.fallback, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if getter, ok := r.getters[target]; ok {
+		return getter, nil
+	}
+
+	getter, err := r.factory(target.partition, target.region, target.account)
+	if err != nil {
+		return nil, errors.Wrapf(err, "awsenv: no backend for account %s in %s (%s)", target.account, target.region, target.partition)
+	}
+	r.getters[target] = getter
+
+	return getter, nil
+}
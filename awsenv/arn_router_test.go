@@ -0,0 +1,248 @@
+package awsenv
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	eastARN = "arn:aws:ssm:us-east-1:111111111111:parameter/shared/secret"
+	westARN = "arn:aws:ssm:us-west-2:222222222222:parameter/shared/secret"
+	govARN  = "arn:aws-us-gov:ssm:us-gov-west-1:333333333333:parameter/shared/secret"
+)
+
+// arnParamStore behaves like Parameter Store queried by ARN: it looks up
+// names by plain path and returns values keyed by plain path.
+type arnParamStore map[string]string
+
+func (m arnParamStore) GetParams(_ context.Context, paths []string) (map[string]string, error) {
+	result := make(map[string]string, len(paths))
+	var invalid []string
+	for _, path := range paths {
+		plain := stripARNPrefix(path)
+		val, ok := m[plain]
+		if !ok {
+			invalid = append(invalid, path)
+			continue
+		}
+		result[plain] = val
+	}
+	if len(invalid) > 0 {
+		return result, &InvalidParamsError{Names: invalid}
+	}
+	return result, nil
+}
+
+type recordingFactory struct {
+	stores map[arnTarget]ParamsGetter
+
+	mu    sync.Mutex
+	calls []arnTarget
+}
+
+func (f *recordingFactory) newGetter(partition, region, account string) (ParamsGetter, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	target := arnTarget{partition, region, account}
+	f.calls = append(f.calls, target)
+
+	getter, ok := f.stores[target]
+	if !ok {
+		return nil, errors.New("no credentials")
+	}
+	return getter, nil
+}
+
+func TestParseSSMARN(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name      string
+		input     string
+		partition string
+		region    string
+		account   string
+		ok        bool
+	}{
+		{
+			name:      "arn",
+			input:     eastARN,
+			partition: "aws",
+			region:    "us-east-1",
+			account:   "111111111111",
+			ok:        true,
+		},
+		{
+			name:      "govcloud_arn",
+			input:     govARN,
+			partition: "aws-us-gov",
+			region:    "us-gov-west-1",
+			account:   "333333333333",
+			ok:        true,
+		},
+		{
+			name:      "china_arn",
+			input:     "arn:aws-cn:ssm:cn-north-1:444444444444:parameter/shared/secret",
+			partition: "aws-cn",
+			region:    "cn-north-1",
+			account:   "444444444444",
+			ok:        true,
+		},
+		{
+			name:      "own_account_arn",
+			input:     "arn:aws:ssm:eu-west-1::parameter/shared/secret",
+			partition: "aws",
+			region:    "eu-west-1",
+			ok:        true,
+		},
+		{
+			name:  "plain_path",
+			input: "/shared/secret",
+		},
+		{
+			name:  "not_at_start",
+			input: "ssm:" + eastARN,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			partition, region, account, ok := parseSSMARN(test.input)
+			require.Equal(t, test.ok, ok)
+			require.Equal(t, test.partition, partition)
+			require.Equal(t, test.region, region)
+			require.Equal(t, test.account, account)
+		})
+	}
+}
+
+func TestARNRouter_GetParams(t *testing.T) {
+	t.Parallel()
+	factory := &recordingFactory{
+		stores: map[arnTarget]ParamsGetter{
+			{"aws", "us-east-1", "111111111111"}:            arnParamStore{"/shared/secret": "east"},
+			{"aws", "us-west-2", "222222222222"}:            arnParamStore{"/shared/secret": "west"},
+			{"aws-us-gov", "us-gov-west-1", "333333333333"}: arnParamStore{"/shared/secret": "gov"},
+		},
+	}
+
+	r := NewARNRouter(mockParamStore{"/shared/secret": "local"}, factory.newGetter)
+
+	names := []string{"/shared/secret", eastARN, westARN, govARN}
+	for i := 0; i < 2; i++ {
+		got, err := r.GetParams(context.Background(), names)
+		require.NoError(t, err)
+
+		want := map[string]string{
+			"/shared/secret": "local",
+			eastARN:          "east",
+			westARN:          "west",
+			govARN:           "gov",
+		}
+		require.Equal(t, want, got)
+	}
+
+	// getters are created once per partition, region and account
+	require.ElementsMatch(t, []arnTarget{
+		{"aws", "us-east-1", "111111111111"},
+		{"aws", "us-west-2", "222222222222"},
+		{"aws-us-gov", "us-gov-west-1", "333333333333"},
+	}, factory.calls)
+}
+
+func TestARNRouter_GetParams_ownAccount(t *testing.T) {
+	t.Parallel()
+	const euARN = "arn:aws:ssm:eu-west-1::parameter/shared/secret"
+
+	// a plain path store, to check that the ARN is not sent
+	factory := &recordingFactory{
+		stores: map[arnTarget]ParamsGetter{
+			{"aws", "eu-west-1", ""}: mockParamStore{"/shared/secret": "eu"},
+		},
+	}
+
+	r := NewARNRouter(mockParamStore{"/shared/secret": "local"}, factory.newGetter)
+
+	got, err := r.GetParams(context.Background(), []string{"/shared/secret", euARN})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"/shared/secret": "local", euARN: "eu"}, got)
+}
+
+func TestARNRouter_GetParams_invalid(t *testing.T) {
+	t.Parallel()
+	factory := &recordingFactory{
+		stores: map[arnTarget]ParamsGetter{
+			{"aws", "us-east-1", "111111111111"}: arnParamStore{},
+		},
+	}
+
+	r := NewARNRouter(arnParamStore{}, factory.newGetter)
+
+	got, err := r.GetParams(context.Background(), []string{"/missing", eastARN})
+	require.Empty(t, got)
+
+	var inv *InvalidParamsError
+	require.True(t, errors.As(err, &inv), "expected an *InvalidParamsError, got %v", err)
+	require.ElementsMatch(t, []string{"/missing", eastARN}, inv.Names)
+}
+
+func TestARNRouter_GetParams_factoryError(t *testing.T) {
+	t.Parallel()
+	factory := &recordingFactory{}
+
+	r := NewARNRouter(mockParamStore{}, factory.newGetter)
+
+	_, err := r.GetParams(context.Background(), []string{westARN})
+	require.EqualError(t, err, "awsenv: no backend for account 222222222222 in us-west-2 (aws): no credentials")
+}
+
+func TestARNRouter_GetParams_noFallback(t *testing.T) {
+	t.Parallel()
+	r := NewARNRouter(nil, (&recordingFactory{}).newGetter)
+
+	_, err := r.GetParams(context.Background(), []string{"/a"})
+	require.EqualError(t, err, `awsenv: no backend for param: "/a"`)
+}
+
+func TestReplacer_ReplaceAll_ARNRouter(t *testing.T) {
+	// Not parallel: this test mutates global environ/setenv via fakeEnv.install()
+	origEnviron := environ
+	origSetenv := setenv
+	t.Cleanup(func() {
+		environ = origEnviron
+		setenv = origSetenv
+	})
+
+	env := fakeEnv{
+		"LOCAL":   "awsenv:/shared/secret",
+		"EAST":    "awsenv:" + eastARN,
+		"WEST":    "awsenv:" + westARN,
+		"WEST_V2": "awsenv:" + westARN + ":2",
+	}
+	env.install()
+
+	factory := &recordingFactory{
+		stores: map[arnTarget]ParamsGetter{
+			{"aws", "us-east-1", "111111111111"}: arnParamStore{"/shared/secret": "east"},
+			{"aws", "us-west-2", "222222222222"}: arnParamStore{"/shared/secret": "west", "/shared/secret:2": "west-v2"},
+		},
+	}
+
+	router := NewARNRouter(mockParamStore{"/shared/secret": "local"}, factory.newGetter)
+	err := NewReplacer(DefaultPrefix, router).ReplaceAll(context.Background())
+	require.NoError(t, err)
+
+	want := fakeEnv{
+		"LOCAL":   "local",
+		"EAST":    "east",
+		"WEST":    "west",
+		"WEST_V2": "west-v2",
+	}
+	require.Equal(t, want, env)
+}
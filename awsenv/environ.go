@@ -22,3 +22,12 @@ func parseEnvironment(env []string) map[string]string {
 
 	return envvars
 }
+
+// copyEnv returns a copy of envvars.
+func copyEnv(envvars map[string]string) map[string]string {
+	dup := make(map[string]string, len(envvars))
+	for key, value := range envvars {
+		dup[key] = value
+	}
+	return dup
+}
@@ -0,0 +1,124 @@
+package awsenv
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrParamNotFound is the reason given for a referenced parameter that
+	// does not exist.
+	ErrParamNotFound = errors.New("param not found")
+
+	// ErrParamInvalid is the reason given for a referenced parameter that
+	// the ParamsGetter reported as invalid. Parameter Store reports
+	// parameters that do not exist as invalid as well.
+	ErrParamInvalid = errors.New("param invalid or not found")
+)
+
+// InvalidParamsError may be returned by a ParamsGetter, together with the
+// values it did retrieve, to report names the data source rejected.
+type InvalidParamsError struct {
+	Names []string
+}
+
+func (e *InvalidParamsError) Error() string {
+	return fmt.Sprintf("awsenv: invalid params: %q", e.Names)
+}
+
+// invalidNames returns the names reported by err if it is an
+// InvalidParamsError. Any other non-nil error is returned as is.
+func invalidNames(err error) (map[string]bool, error) {
+	var invalid *InvalidParamsError
+	if !errors.As(err, &invalid) {
+		return nil, err
+	}
+
+	names := make(map[string]bool, len(invalid.Names))
+	for _, name := range invalid.Names {
+		names[name] = true
+	}
+
+	return names, nil
+}
+
+// ParamError describes a single reference that could not be resolved.
+type ParamError struct {
+	// Source is where the reference was found: an env var name, or a file
+	// name and line number.
+	Source string
+	// Name is the referenced parameter.
+	Name string
+	// Err is the reason, such as ErrParamNotFound or ErrParamInvalid.
+	Err error
+}
+
+func (e *ParamError) Error() string {
+	return fmt.Sprintf("%s: %q: %v", e.Source, e.Name, e.Err)
+}
+
+// Unwrap returns the reason the reference could not be resolved.
+func (e *ParamError) Unwrap() error {
+	return e.Err
+}
+
+// ResolveError is returned when one or more references could not be
+// resolved. It lists every one of them, ordered by source.
+type ResolveError struct {
+	Errors []*ParamError
+}
+
+func (e *ResolveError) Error() string {
+	if len(e.Errors) == 1 {
+		return "awsenv: " + e.Errors[0].Error()
+	}
+
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+
+	return fmt.Sprintf("awsenv: %d references could not be resolved: %s", len(e.Errors), strings.Join(msgs, "; "))
+}
+
+// Unwrap returns the errors of the individual references.
+func (e *ResolveError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, err := range e.Errors {
+		errs[i] = err
+	}
+	return errs
+}
+
+// add records that the reference to name found in source failed with err.
+func (e *ResolveError) add(source, name string, err error) {
+	e.Errors = append(e.Errors, &ParamError{
+		Source: source,
+		Name:   name,
+		Err:    err,
+	})
+}
+
+// err returns e, with the reason for missing params replaced by the one in
+// reasons, if any, or nil if no errors were recorded.
+func (e *ResolveError) err(reasons map[string]error) error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+
+	for _, err := range e.Errors {
+		if err.Err != ErrParamNotFound {
+			continue
+		}
+
+		if reason, ok := reasons[err.Name]; ok {
+			err.Err = reason
+		} else if reason, ok := reasons[stripARNPrefix(err.Name)]; ok {
+			err.Err = reason
+		}
+	}
+
+	return e
+}
@@ -0,0 +1,154 @@
+package awsenv
+
+import (
+	"bufio"
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultExpandPrefix holds the standard prefix of env values whose
+// parameter holds many env vars, as a JSON object or in dotenv format.
+var DefaultExpandPrefix = "awsenv-expand:"
+
+// envNamePattern matches valid environment variable names.
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// parseExpansion parses a parameter value holding many env vars. Values
+// beginning with "{" are parsed as a JSON object, anything else as dotenv.
+func parseExpansion(val string) (map[string]string, error) {
+	var (
+		vars map[string]string
+		err  error
+	)
+
+	if strings.HasPrefix(strings.TrimSpace(val), "{") {
+		vars, err = parseJSONExpansion(val)
+	} else {
+		vars, err = parseDotenv(val)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for name := range vars {
+		if !envNamePattern.MatchString(name) {
+			return nil, errors.Errorf("invalid env var name %q", name)
+		}
+	}
+
+	return vars, nil
+}
+
+// parseJSONExpansion parses a JSON object into env vars. String values are
+// used as is, other values in their JSON form.
+func parseJSONExpansion(val string) (map[string]string, error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal([]byte(val), &doc); err != nil {
+		return nil, errors.Wrap(err, "invalid JSON object")
+	}
+
+	vars := make(map[string]string, len(doc))
+	for name, field := range doc {
+		switch field := field.(type) {
+		case string:
+			vars[name] = field
+		case nil:
+			vars[name] = ""
+		default:
+			b, err := json.Marshal(field)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid value for %q", name)
+			}
+			vars[name] = string(b)
+		}
+	}
+
+	return vars, nil
+}
+
+// parseDotenv parses lines of the form NAME=value, optionally preceded by
+// "export". Blank lines and lines beginning with "#" are ignored. Values
+// may be single quoted (taken literally) or double quoted (supporting \n,
+// \t, \", \\ and \$ escapes).
+func parseDotenv(val string) (map[string]string, error) {
+	vars := make(map[string]string)
+
+	scanner := bufio.NewScanner(strings.NewReader(val))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+
+		idx := strings.Index(line, "=")
+		if idx < 0 {
+			return nil, errors.Errorf("line %d: expected NAME=value", n)
+		}
+
+		name, value := strings.TrimSpace(line[:idx]), strings.TrimSpace(line[idx+1:])
+
+		value, err := unquoteDotenv(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "line %d", n)
+		}
+
+		vars[name] = value
+	}
+
+	return vars, scanner.Err()
+}
+
+// unquoteDotenv removes quotes from a dotenv value. Unquoted values end at
+// an inline " #" comment.
+func unquoteDotenv(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	switch quote := value[0]; quote {
+	case '\'':
+		end := strings.IndexByte(value[1:], '\'')
+		if end < 0 {
+			return "", errors.New("unterminated single quote")
+		}
+		return value[1 : end+1], nil
+	case '"':
+		var b strings.Builder
+		for i := 1; i < len(value); i++ {
+			c := value[i]
+			switch {
+			case c == '"':
+				return b.String(), nil
+			case c == '\\' && i+1 < len(value):
+				i++
+				switch value[i] {
+				case 'n':
+					b.WriteByte('\n')
+				case 't':
+					b.WriteByte('\t')
+				case 'r':
+					b.WriteByte('\r')
+				case '"', '\\', '$':
+					b.WriteByte(value[i])
+				default:
+					b.WriteByte('\\')
+					b.WriteByte(value[i])
+				}
+			default:
+				b.WriteByte(c)
+			}
+		}
+		return "", errors.New("unterminated double quote")
+	}
+
+	if idx := strings.Index(value, " #"); idx >= 0 {
+		value = strings.TrimSpace(value[:idx])
+	}
+
+	return value, nil
+}
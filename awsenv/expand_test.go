@@ -0,0 +1,177 @@
+package awsenv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseExpansion(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		input   string
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name:  "json",
+			input: `{"DB_HOST":"db","DB_PORT":5432,"DEBUG":true,"EMPTY":null}`,
+			want:  map[string]string{"DB_HOST": "db", "DB_PORT": "5432", "DEBUG": "true", "EMPTY": ""},
+		},
+		{
+			name:  "json_with_whitespace",
+			input: "\n  {\"DB_HOST\": \"db\"}\n",
+			want:  map[string]string{"DB_HOST": "db"},
+		},
+		{
+			name: "dotenv",
+			input: `
+# database
+DB_HOST=db
+export DB_USER = app
+DB_PASS='p@ss $word # not a comment'
+DB_OPTS="a=1\nb=\"2\""
+DB_NAME=app # the name
+EMPTY=
+`,
+			want: map[string]string{
+				"DB_HOST": "db",
+				"DB_USER": "app",
+				"DB_PASS": "p@ss $word # not a comment",
+				"DB_OPTS": "a=1\nb=\"2\"",
+				"DB_NAME": "app",
+				"EMPTY":   "",
+			},
+		},
+		{
+			name:    "invalid_json",
+			input:   `{"DB_HOST":`,
+			wantErr: "invalid JSON object: unexpected end of JSON input",
+		},
+		{
+			name:    "invalid_dotenv_line",
+			input:   "DB_HOST=db\nDB_USER",
+			wantErr: "line 2: expected NAME=value",
+		},
+		{
+			name:    "unterminated_quote",
+			input:   `DB_HOST="db`,
+			wantErr: "line 1: unterminated double quote",
+		},
+		{
+			name:    "invalid_name",
+			input:   `{"db-host":"db"}`,
+			wantErr: `invalid env var name "db-host"`,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := parseExpansion(test.input)
+			if test.wantErr != "" {
+				require.EqualError(t, err, test.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestReplacer_ReplaceAll_Expand(t *testing.T) {
+	// Not parallel: this test mutates global environ/setenv via fakeEnv.install()
+	origEnviron := environ
+	origSetenv := setenv
+	t.Cleanup(func() {
+		environ = origEnviron
+		setenv = origSetenv
+	})
+
+	env := fakeEnv{
+		"APP_CONFIG": "awsenv-expand:/prod/app/config",
+		"DB_CONFIG":  "awsenv-expand:/prod/app/db#/primary?prefix=DB_",
+		"DB_HOST":    "localhost", // already set, not overwritten
+		"SECRET":     "awsenv:/prod/app/secret",
+	}
+	env.install()
+
+	params := mockParamStore{
+		"/prod/app/config": "LOG_LEVEL=debug\nDB_HOST=remote\n",
+		"/prod/app/db":     `{"primary":{"USER":"app","NAME":"main"}}`,
+		"/prod/app/secret": "s3cr3t",
+	}
+
+	r := NewReplacer(DefaultPrefix, params)
+	err := r.ReplaceAll(context.Background())
+	require.NoError(t, err)
+
+	want := fakeEnv{
+		"APP_CONFIG": "LOG_LEVEL=debug\nDB_HOST=remote\n",
+		"DB_CONFIG":  `{"NAME":"main","USER":"app"}`,
+		"DB_HOST":    "localhost",
+		"DB_USER":    "app",
+		"DB_NAME":    "main",
+		"LOG_LEVEL":  "debug",
+		"SECRET":     "s3cr3t",
+	}
+	require.Equal(t, want, env)
+}
+
+func TestReplacer_ReplaceAll_ExpandOverride(t *testing.T) {
+	// Not parallel: this test mutates global environ/setenv via fakeEnv.install()
+	origEnviron := environ
+	origSetenv := setenv
+	t.Cleanup(func() {
+		environ = origEnviron
+		setenv = origSetenv
+	})
+
+	env := fakeEnv{
+		"APP_CONFIG": "expand:/prod/app/config",
+		"DB_HOST":    "localhost",
+	}
+	env.install()
+
+	params := mockParamStore{
+		"/prod/app/config": `{"DB_HOST":"remote"}`,
+	}
+
+	r := NewReplacer(DefaultPrefix, params, WithExpandPrefix("expand:"), WithExpandOverride(true))
+	err := r.ReplaceAll(context.Background())
+	require.NoError(t, err)
+
+	want := fakeEnv{
+		"APP_CONFIG": `{"DB_HOST":"remote"}`,
+		"DB_HOST":    "remote",
+	}
+	require.Equal(t, want, env)
+}
+
+func TestReplacer_ReplaceAll_ExpandConflict(t *testing.T) {
+	// Not parallel: this test mutates global environ/setenv via fakeEnv.install()
+	origEnviron := environ
+	origSetenv := setenv
+	t.Cleanup(func() {
+		environ = origEnviron
+		setenv = origSetenv
+	})
+
+	env := fakeEnv{
+		"A_CONFIG": "awsenv-expand:/a",
+		"B_CONFIG": "awsenv-expand:/b",
+	}
+	env.install()
+
+	params := mockParamStore{
+		"/a": "DB_HOST=a",
+		"/b": "DB_HOST=b",
+	}
+
+	r := NewReplacer(DefaultPrefix, params)
+	err := r.ReplaceAll(context.Background())
+	require.EqualError(t, err, `awsenv: B_CONFIG: "/b": DB_HOST is also expanded from A_CONFIG with a different value`)
+}
@@ -2,6 +2,7 @@ package awsenv
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -15,18 +16,25 @@ var (
 
 // FileReplacer handles replacing the first instance per line of a prefixed
 // field  and updating it with a value retrieved from AWS Parameter Store.
+//
+// Lines that embed references delimited as ${<prefix><reference>} have
+// every one of them replaced instead, in the same way as Replacer.
+//
+// Placeholders in reference paths, e.g. `/${STAGE}/my-app/db`, are filled
+// from WithVars and then from the environment.
 type FileReplacer struct {
 	ssm      ParamsGetter
 	prefix   string
 	fileName string
 	perms    os.FileMode
+	options
 }
 
 // NewFileReplacer takes a prefix to look for, and a ParamGetter that it will
 // use to fetch the values from Parameter Store
 //
 // If the prefix is an empty string then the constructor will panic
-func NewFileReplacer(prefix, fileName string, ssm ParamsGetter) *FileReplacer {
+func NewFileReplacer(prefix, fileName string, ssm ParamsGetter, opts ...Option) *FileReplacer {
 
 	if prefix == "" {
 		panic("awsenv: prefix must be non-empty")
@@ -47,13 +55,16 @@ func NewFileReplacer(prefix, fileName string, ssm ParamsGetter) *FileReplacer {
 		prefix:   prefix,
 		fileName: fileName,
 		perms:    perms,
+		options:  newOptions(opts).withGetter(ssm),
 	}
 }
 
 // ReplaceAll overwrites the first instance of every prefix-matching field
-// per line with values retrieved from Parameter Store. ReplaceAll will
+// per line, or every delimited reference, with values retrieved from
+// Parameter Store. ReplaceAll will
 // attempt to replace as many values as possible, after which it will
-// return the first error that occurred.
+// return a *ResolveError listing every reference that could not be
+// resolved, if any.
 func (r *FileReplacer) ReplaceAll(ctx context.Context) error {
 
 	f, err := readFile(r.fileName)
@@ -62,55 +73,113 @@ func (r *FileReplacer) ReplaceAll(ctx context.Context) error {
 	}
 
 	lines := strings.Split(string(f), "\n")
-	replacementIndices := make(map[string][]replacementIndex, 8)
+	replacements := make([]replacementIndex, 0, 8)
 	paths := make([]string, 0, 8)
+	var templates []lineTemplate
+
+	var rerr ResolveError
+
+	lookup := r.placeholderLookup(parseEnvironment(environ()))
 
 	// find the paths that need replacing
 	for i, line := range lines {
 
+		if isTemplate(line, r.prefix) {
+			line, raw, err := expandTemplatePlaceholders(line, r.prefix, lookup)
+			if err != nil {
+				rerr.add(r.source(i), raw, err)
+				continue
+			}
+
+			tmpl, err := r.template(line, r.prefix)
+			if err != nil {
+				rerr.add(r.source(i), strings.TrimSpace(line), err)
+				continue
+			}
+
+			templates = append(templates, lineTemplate{lineNumber: i, tmpl: tmpl})
+			paths = append(paths, tmpl.paths()...)
+			continue
+		}
+
 		idx := strings.Index(line, r.prefix)
 		if idx < 0 {
 			// no prefix found in line
 			continue
 		}
 
-		path := strings.FieldsFunc(line[idx+len(r.prefix):], splitPath)[0]
-		plainPath := stripARNPrefix(path)
+		path, fieldIdx := scanReference(line[idx+len(r.prefix):], r.transforms)
+		if path == "" {
+			continue
+		}
+
+		raw, err := expandPlaceholders(path, lookup)
+		if err != nil {
+			rerr.add(r.source(i), path, err)
+			continue
+		}
 
-		// if we haven't seen the path yet, init the slice
-		if _, ok := replacementIndices[plainPath]; !ok {
-			replacementIndices[plainPath] = make([]replacementIndex, 0, 4)
+		ref, err := r.reference(raw)
+		if err != nil {
+			rerr.add(r.source(i), raw, err)
+			continue
 		}
 
-		replacementIndices[plainPath] = append(replacementIndices[plainPath], replacementIndex{
+		replacement := replacementIndex{
 			lineNumber:   i,
 			index:        idx,
 			originalPath: path,
-		})
-		paths = append(paths, path)
+			ref:          ref,
+		}
+		paths = append(paths, ref.paths()...)
+
+		// the "#" may instead be text following the reference, which is
+		// only known once the value is
+		if fieldIdx >= 0 {
+			raw, err := expandPlaceholders(path[:fieldIdx], lookup)
+			if err == nil {
+				plain, err := r.reference(raw)
+				if err == nil {
+					replacement.plain = &plain
+					replacement.fieldIdx = fieldIdx
+					paths = append(paths, plain.paths()...)
+				}
+			}
+		}
+
+		replacements = append(replacements, replacement)
 	}
 
 	// fetch the values for the paths
-	paramValues, err := fetch(ctx, r.ssm, paths)
+	paramValues, reasons, err := fetchNested(ctx, r.ssm, paths, r.prefix, r.options)
 	if err != nil {
 		return err
 	}
 
 	// for each param we found, replace the corresponding line
-	for path, value := range paramValues {
-		replacements, ok := replacementIndices[path]
+	for _, replacement := range replacements {
 
-		// this shouldn't really happen
-		if !ok {
-			continue
+		ln := replacement.lineNumber
+		idx := replacement.index
+		ref, originalPath := replacement.ref, replacement.originalPath
+		if replacement.plain != nil {
+			// a field is only selected from a JSON document
+			if val, _, ok := replacement.plain.lookup(paramValues); ok && !json.Valid([]byte(val)) {
+				ref, originalPath = *replacement.plain, originalPath[:replacement.fieldIdx]
+			}
 		}
 
-		for _, replacement := range replacements {
-
-			ln := replacement.lineNumber
-			idx := replacement.index
-			lines[ln] = fmt.Sprintf("%s%s%s", lines[ln][:idx], value, lines[ln][idx+len(r.prefix)+len(replacement.originalPath):])
+		val, err := ref.resolve(paramValues, r.transforms)
+		if err != nil {
+			rerr.add(r.source(ln), ref.path, err)
+			continue
 		}
+		r.searched(r.source(ln), ref, paramValues)
+		lines[ln] = fmt.Sprintf("%s%s%s", lines[ln][:idx], val, lines[ln][idx+len(r.prefix)+len(originalPath):])
+	}
+
+	for _, t := range templates {
+		lines[t.lineNumber], _ = t.tmpl.render(paramValues, r.options, r.source(t.lineNumber), &rerr)
 	}
 
 	newContent := strings.Join(lines, "\n")
@@ -119,7 +188,12 @@ func (r *FileReplacer) ReplaceAll(ctx context.Context) error {
 		return err
 	}
 
-	return nil
+	return rerr.err(reasons)
+}
+
+// source describes the line with the given index for error messages.
+func (r *FileReplacer) source(idx int) string {
+	return fmt.Sprintf("%s:%d", r.fileName, idx+1)
 }
 
 // MustReplaceAll overwrites the applicable environment and generates a panic if something goes wrong.
@@ -134,6 +208,115 @@ type replacementIndex struct {
 	lineNumber   int
 	index        int
 	originalPath string
+	ref          reference
+	// plain is ref without the "#" at fieldIdx in originalPath and the
+	// text after it, used if the value is not a JSON document.
+	plain    *reference
+	fieldIdx int
+}
+
+// lineTemplate is a line embedding references as ${<prefix><reference>}.
+type lineTemplate struct {
+	lineNumber int
+	tmpl       template
+}
+
+// scanReference returns the reference at the start of s. It ends at the first
+// character that is not valid in a Parameter Store path, so that a reference
+// may be followed by other text, e.g. `awsenv:/prod/db?sslmode=require`.
+// A "#field" is included, and its index returned, or -1 if there is none.
+// "?" options are included only if every one of them is a known option, and
+// "|" transforms only if every one of them is a built-in transform or one in
+// custom. Placeholders delimited as ${NAME} are included whole.
+func scanReference(s string, custom map[string]Transform) (string, int) {
+	end := scanPath(s, splitPath)
+
+	fieldIdx := -1
+	if strings.HasPrefix(s[end:], "#") {
+		fieldIdx = end
+		end += 1 + scanPath(s[end+1:], splitField)
+	}
+
+	if strings.HasPrefix(s[end:], "?") {
+		n := scanPath(s[end+1:], splitOption)
+		var ref reference
+		if n > 0 && ref.parseOptions(s[end+1:end+1+n]) == nil {
+			end += 1 + n
+		}
+	}
+
+	if strings.HasPrefix(s[end:], "|") {
+		n := scanPath(s[end+1:], splitTransforms)
+		if n > 0 && knownTransforms(s[end+1:end+1+n], custom) {
+			end += 1 + n
+		}
+	}
+
+	return s[:end], fieldIdx
+}
+
+// knownTransforms reports whether s is made of "|"-separated transforms that
+// are each a built-in transform or one in custom.
+func knownTransforms(s string, custom map[string]Transform) bool {
+	calls, err := parseTransforms(s)
+	if err != nil {
+		return false
+	}
+	for _, call := range calls {
+		if _, ok := custom[call.name]; ok {
+			continue
+		}
+		if _, ok := builtinTransforms[call.name]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// scanPath returns the length of the text at the start of s up to the first
+// rune for which split returns true. Placeholders delimited as ${NAME} are
+// included whole.
+func scanPath(s string, split func(rune) bool) int {
+	skip := 0
+	for i, r := range s {
+		if i < skip {
+			continue
+		}
+		if strings.HasPrefix(s[i:], "${") {
+			end := strings.Index(s[i:], "}")
+			if end < 0 {
+				return i
+			}
+			skip = i + end + 1
+			continue
+		}
+		if split(r) {
+			return i
+		}
+	}
+	return len(s)
+}
+
+// return false if the given rune is acceptable in a JSON field name or
+// pointer
+func splitField(r rune) bool {
+	return splitPath(r) && r != '~'
+}
+
+// return false if the given rune is acceptable in URL query options
+func splitOption(r rune) bool {
+	return splitPath(r) && !isOptionRune(r)
+}
+
+// return false if the given rune is acceptable in "|"-separated transforms
+func splitTransforms(r rune) bool {
+	return splitOption(r) && r != '|'
+}
+
+// return true if the given rune is acceptable in URL query options, in
+// addition to those accepted in a Parameter Store path
+func isOptionRune(r rune) bool {
+	return r == '=' || r == '&' || r == '%' || r == '+'
 }
 
 // return false if the given rune isn't an acceptable Parameter Store path
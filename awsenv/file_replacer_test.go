@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/gofrs/uuid"
@@ -85,6 +86,72 @@ mysql_users:
 		password = "awsenv:arn:aws:ssm:us-east-1:123456789012:parameter/remote/password",
 	}
  )
+`
+	sampleCnfFile7 = `
+mysql_users:
+ (
+	{
+		username = "awsenv:/path/to/the/credentials#username",
+		password = "awsenv:/path/to/the/credentials#/secret/password",
+	}
+ )
+`
+	sampleCnfFile8 = `
+mysql_users:
+ (
+	{
+		username = "awsenv:/path/to/the/username:3",
+		password = "awsenv:/path/to/the/password:prod-label",
+	}
+ )
+`
+	sampleCnfFile9 = `
+mysql_users:
+ (
+	{
+		username = "awsenv:/path/to/the/username?default=nobody",
+		password = "awsenv:/path/to/the/password?default=default%20pass",
+		default_schema = "awsenv:/path/to/the/schema?optional",
+	}
+ )
+`
+	sampleCnfFile10 = `
+mysql_users:
+ (
+	{
+		username = "awsenv:arn:aws-us-gov:ssm:us-gov-west-1:123456789012:parameter/remote/username",
+		password = "awsenv:arn:aws-cn:ssm:cn-north-1:123456789012:parameter/remote/password",
+	}
+ )
+`
+	sampleCnfFile11 = `
+mysql_users:
+ (
+	{
+		username = "awsenv:/path/to/the/username",
+		url = "mysql://${awsenv:/path/to/the/username}:${awsenv:/path/to/the/password}@db/app",
+		comment = "written as $${awsenv:/path}",
+	}
+ )
+`
+	sampleCnfFile12 = `
+mysql_users:
+ (
+	{
+		username = "awsenv:/path/to/the/username|trim|upper",
+		password = "awsenv:/path/to/the/password|base64decode|redact",
+	}
+ )
+`
+	sampleCnfFile13 = `
+mysql_users:
+ (
+	{
+		username = "awsenv:/${STAGE}/the/username",
+		url = "mysql://${awsenv:/${STAGE}/the/username}:${awsenv:/${STAGE}/${SECRET}}@db/app",
+		region = "awsenv:/${REGION}/region",
+	}
+ )
 `
 )
 
@@ -281,6 +348,109 @@ mysql_users:
 	require.Equal(t, expectedContent, string(f))
 }
 
+func TestFileReplacer_ReplaceAll_Interpolation(t *testing.T) {
+
+	fileName, cleanup := writeTempFile(sampleCnfFile11)
+	defer cleanup()
+
+	params := mockParamStore{
+		"/path/to/the/username": "app",
+		"/path/to/the/password": "s3cr3t",
+	}
+
+	r := NewFileReplacer(DefaultPrefix, fileName, params)
+
+	ctx := context.Background()
+	err := r.ReplaceAll(ctx)
+	require.NoError(t, err, "expected no error")
+
+	expectedContent := `
+mysql_users:
+ (
+	{
+		username = "app",
+		url = "mysql://app:s3cr3t@db/app",
+		comment = "written as ${awsenv:/path}",
+	}
+ )
+`
+	f, err := ioutil.ReadFile(fileName) //nolint: gosec
+	require.NoError(t, err)
+
+	require.Equal(t, expectedContent, string(f))
+}
+
+func TestFileReplacer_ReplaceAll_Transforms(t *testing.T) {
+
+	fileName, cleanup := writeTempFile(sampleCnfFile12)
+	defer cleanup()
+
+	params := mockParamStore{
+		"/path/to/the/username": " app\n",
+		"/path/to/the/password": "czNjcjN0",
+	}
+
+	redact := func(val, _ string) (string, error) {
+		return strings.Repeat("*", len(val)), nil
+	}
+
+	r := NewFileReplacer(DefaultPrefix, fileName, params, WithTransform("redact", redact))
+
+	ctx := context.Background()
+	err := r.ReplaceAll(ctx)
+	require.NoError(t, err, "expected no error")
+
+	expectedContent := `
+mysql_users:
+ (
+	{
+		username = "APP",
+		password = "******",
+	}
+ )
+`
+	f, err := ioutil.ReadFile(fileName) //nolint: gosec
+	require.NoError(t, err)
+
+	require.Equal(t, expectedContent, string(f))
+}
+
+func TestFileReplacer_ReplaceAll_PartitionARN(t *testing.T) {
+
+	fileName, cleanup := writeTempFile(sampleCnfFile10)
+	defer cleanup()
+
+	getter := NewARNRouter(nil, func(partition, region, account string) (ParamsGetter, error) {
+		return mockParamsGetter(func(_ context.Context, paths []string) (map[string]string, error) {
+			result := make(map[string]string, len(paths))
+			for _, p := range paths {
+				result[stripARNPrefix(p)] = partition + "/" + region
+			}
+			return result, nil
+		}), nil
+	})
+
+	r := NewFileReplacer(DefaultPrefix, fileName, getter)
+
+	ctx := context.Background()
+	err := r.ReplaceAll(ctx)
+	require.NoError(t, err, "expected no error")
+
+	expectedContent := `
+mysql_users:
+ (
+	{
+		username = "aws-us-gov/us-gov-west-1",
+		password = "aws-cn/cn-north-1",
+	}
+ )
+`
+	f, err := ioutil.ReadFile(fileName) //nolint: gosec
+	require.NoError(t, err)
+
+	require.Equal(t, expectedContent, string(f))
+}
+
 func TestFileReplacer_ReplaceAll_MixedLocalAndCrossAccount(t *testing.T) {
 
 	fileName, cleanup := writeTempFile(sampleCnfFile6)
@@ -328,6 +498,152 @@ mysql_users:
 	require.Equal(t, expectedContent, string(f))
 }
 
+func TestFileReplacer_ReplaceAll_JSONField(t *testing.T) {
+
+	fileName, cleanup := writeTempFile(sampleCnfFile7)
+	defer cleanup()
+
+	params := mockParamStore{
+		"/path/to/the/credentials": `{"username":"user","secret":{"password":"password"}}`,
+	}
+	r := NewFileReplacer(DefaultPrefix, fileName, params)
+
+	ctx := context.Background()
+	err := r.ReplaceAll(ctx)
+	require.NoError(t, err, "expected no error")
+
+	expectedContent := `
+mysql_users:
+ (
+	{
+		username = "user",
+		password = "password",
+	}
+ )
+`
+	f, err := ioutil.ReadFile(fileName) //nolint: gosec
+	require.NoError(t, err)
+
+	require.Equal(t, expectedContent, string(f))
+}
+
+func TestFileReplacer_ReplaceAll_JSONFieldMissing(t *testing.T) {
+
+	fileName, cleanup := writeTempFile(sampleCnfFile7)
+	defer cleanup()
+
+	params := mockParamStore{
+		"/path/to/the/credentials": `{"username":"user"}`,
+	}
+	r := NewFileReplacer(DefaultPrefix, fileName, params)
+
+	ctx := context.Background()
+	err := r.ReplaceAll(ctx)
+	require.EqualError(t, err, `awsenv: `+fileName+`:6: "/path/to/the/credentials": field "/secret/password" not found`)
+
+	// the references that could be resolved are still replaced
+	expectedContent := `
+mysql_users:
+ (
+	{
+		username = "user",
+		password = "awsenv:/path/to/the/credentials#/secret/password",
+	}
+ )
+`
+	f, err := ioutil.ReadFile(fileName) //nolint: gosec
+	require.NoError(t, err)
+	require.Equal(t, expectedContent, string(f))
+}
+
+func TestFileReplacer_ReplaceAll_Selectors(t *testing.T) {
+
+	fileName, cleanup := writeTempFile(sampleCnfFile8)
+	defer cleanup()
+
+	params := mockParamStore{
+		"/path/to/the/username:3":          "user_v3",
+		"/path/to/the/password:prod-label": "password_prod",
+	}
+	r := NewFileReplacer(DefaultPrefix, fileName, params)
+
+	ctx := context.Background()
+	err := r.ReplaceAll(ctx)
+	require.NoError(t, err, "expected no error")
+
+	expectedContent := `
+mysql_users:
+ (
+	{
+		username = "user_v3",
+		password = "password_prod",
+	}
+ )
+`
+	f, err := ioutil.ReadFile(fileName) //nolint: gosec
+	require.NoError(t, err)
+
+	require.Equal(t, expectedContent, string(f))
+}
+
+func TestFileReplacer_ReplaceAll_Defaults(t *testing.T) {
+
+	fileName, cleanup := writeTempFile(sampleCnfFile9)
+	defer cleanup()
+
+	params := lenientParamStore{
+		"/path/to/the/username": "user",
+	}
+	r := NewFileReplacer(DefaultPrefix, fileName, params)
+
+	ctx := context.Background()
+	err := r.ReplaceAll(ctx)
+	require.NoError(t, err, "expected no error")
+
+	expectedContent := `
+mysql_users:
+ (
+	{
+		username = "user",
+		password = "default pass",
+		default_schema = "",
+	}
+ )
+`
+	f, err := ioutil.ReadFile(fileName) //nolint: gosec
+	require.NoError(t, err)
+
+	require.Equal(t, expectedContent, string(f))
+}
+
+func TestFileReplacer_ReplaceAll_ReportsEveryError(t *testing.T) {
+
+	fileName, cleanup := writeTempFile(sampleCnfFile4)
+	defer cleanup()
+
+	params := lenientParamStore{
+		"/path/to/the/username": "user",
+	}
+	r := NewFileReplacer(DefaultPrefix, fileName, params)
+
+	ctx := context.Background()
+	err := r.ReplaceAll(ctx)
+
+	var rerr *ResolveError
+	require.True(t, errors.As(err, &rerr), "expected a *ResolveError, got %v", err)
+	require.Len(t, rerr.Errors, 2)
+	for i, line := range []int{6, 12} {
+		require.Equal(t, fmt.Sprintf("%s:%d", fileName, line), rerr.Errors[i].Source)
+		require.Equal(t, "/path/to/the/password", rerr.Errors[i].Name)
+		require.True(t, errors.Is(rerr.Errors[i], ErrParamNotFound))
+	}
+
+	f, err := ioutil.ReadFile(fileName) //nolint: gosec
+	require.NoError(t, err)
+	require.Contains(t, string(f), `admin_username = "user",`)
+	require.Contains(t, string(f), `admin_password = "awsenv:/path/to/the/password",`)
+}
+
 func writeTempFile(contents string) (string, func()) {
 
 	uid, err := uuid.NewV4()
@@ -350,3 +666,142 @@ func writeTempFile(contents string) (string, func()) {
 
 	return tmpfile.Name(), func() { os.Remove(tmpfile.Name()) } //nolint: errcheck,gosec
 }
+
+func TestFileReplacer_ReplaceAll_Placeholders(t *testing.T) {
+	// Not parallel: this test mutates global environ via fakeEnv.install()
+	origEnviron := environ
+	origSetenv := setenv
+	t.Cleanup(func() {
+		environ = origEnviron
+		setenv = origSetenv
+	})
+
+	env := fakeEnv{"STAGE": "prod", "SECRET": "ignored"}
+	env.install()
+
+	fileName, cleanup := writeTempFile(sampleCnfFile13)
+	defer cleanup()
+
+	params := lenientParamStore{
+		"/prod/the/username": "app",
+		"/prod/the/password": "s3cr3t",
+	}
+
+	r := NewFileReplacer(DefaultPrefix, fileName, params, WithVars(map[string]string{"SECRET": "the/password"}))
+
+	ctx := context.Background()
+	err := r.ReplaceAll(ctx)
+	require.EqualError(t, err, fmt.Sprintf(`awsenv: %s:7: "/${REGION}/region": undefined placeholder ${REGION}`, fileName))
+
+	expectedContent := `
+mysql_users:
+ (
+	{
+		username = "app",
+		url = "mysql://app:s3cr3t@db/app",
+		region = "awsenv:/${REGION}/region",
+	}
+ )
+`
+	f, err := ioutil.ReadFile(fileName) //nolint: gosec
+	require.NoError(t, err)
+
+	require.Equal(t, expectedContent, string(f))
+}
+
+func TestFileReplacer_ReplaceAll_QueryAfterReference(t *testing.T) {
+
+	fileName, cleanup := writeTempFile(`
+dsn=awsenv:/prod/db?sslmode=require
+mixed=awsenv:/prod/db?default=x&sslmode=require
+opt=awsenv:/prod/missing?default=none
+`)
+	defer cleanup()
+
+	params := lenientParamStore{
+		"/prod/db": "postgres://db/app",
+	}
+	r := NewFileReplacer(DefaultPrefix, fileName, params)
+
+	ctx := context.Background()
+	err := r.ReplaceAll(ctx)
+	require.NoError(t, err, "expected no error")
+
+	// a query that is not made of reference options is left as it is
+	expectedContent := `
+dsn=postgres://db/app?sslmode=require
+mixed=postgres://db/app?default=x&sslmode=require
+opt=none
+`
+	f, err := ioutil.ReadFile(fileName) //nolint: gosec
+	require.NoError(t, err)
+
+	require.Equal(t, expectedContent, string(f))
+}
+
+func TestFileReplacer_ReplaceAll_PipeAfterReference(t *testing.T) {
+
+	fileName, cleanup := writeTempFile(`
+x=awsenv:/a|rest
+y=awsenv:/a|upper|rest
+z=awsenv:/a|upper|redact
+`)
+	defer cleanup()
+
+	params := mockParamStore{
+		"/a": "a",
+	}
+
+	redact := func(val, _ string) (string, error) {
+		return strings.Repeat("*", len(val)), nil
+	}
+
+	r := NewFileReplacer(DefaultPrefix, fileName, params, WithTransform("redact", redact))
+
+	ctx := context.Background()
+	err := r.ReplaceAll(ctx)
+	require.NoError(t, err, "expected no error")
+
+	// text after a "|" that is not made of transforms is left as it is
+	expectedContent := `
+x=a|rest
+y=a|upper|rest
+z=*
+`
+	f, err := ioutil.ReadFile(fileName) //nolint: gosec
+	require.NoError(t, err)
+
+	require.Equal(t, expectedContent, string(f))
+}
+
+func TestFileReplacer_ReplaceAll_FragmentAfterReference(t *testing.T) {
+
+	fileName, cleanup := writeTempFile(`
+x=awsenv:/a#frag
+y=awsenv:/a#frag?x=1
+user=awsenv:/creds#username
+`)
+	defer cleanup()
+
+	params := mockParamStore{
+		"/a":     "A",
+		"/creds": `{"username": "admin"}`,
+	}
+
+	r := NewFileReplacer(DefaultPrefix, fileName, params)
+
+	ctx := context.Background()
+	err := r.ReplaceAll(ctx)
+	require.NoError(t, err, "expected no error")
+
+	// a "#" after a value that is not a JSON document is left as it is
+	expectedContent := `
+x=A#frag
+y=A#frag?x=1
+user=admin
+`
+	f, err := ioutil.ReadFile(fileName) //nolint: gosec
+	require.NoError(t, err)
+
+	require.Equal(t, expectedContent, string(f))
+}
@@ -1,12 +1,59 @@
 package awsenv
 
-import "regexp"
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
 
-// ssmARNPrefix matches the fully qualified SSM parameter ARN prefix used for cross-account parameters.
-// note: this will not cover AWS GovCloud ARNs
+// ssmARNPrefix matches the fully qualified SSM parameter ARN prefix used for cross-account parameters,
+// in any partition (e.g. aws, aws-cn, aws-us-gov, aws-iso). The account may
+// be left out, for a parameter in another region of the caller's account.
 //
-//	example: `arn:aws:ssm:<region>:<account_id>:parameter<parameter_path>`
-var ssmARNPrefix = regexp.MustCompile(`arn:aws:ssm:[^:]+:[^:]+:parameter`)
+//	example: `arn:<partition>:ssm:<region>:<account_id>:parameter<parameter_path>`
+var ssmARNPrefix = regexp.MustCompile(`arn:aws[a-z-]*:ssm:[^:]+:[^:]*:parameter`)
+
+// ssmARN matches a fully qualified SSM parameter ARN, capturing its
+// partition, region and account.
+var ssmARN = regexp.MustCompile(`^arn:(aws[a-z-]*):ssm:([^:]+):([^:]*):parameter`)
+
+// regionPartitions maps region name prefixes to the partitions outside aws.
+// Longer prefixes come first.
+var regionPartitions = []struct{ prefix, partition string }{
+	{"us-isob-", "aws-iso-b"},
+	{"us-iso-", "aws-iso"},
+	{"eu-isoe-", "aws-iso-e"},
+	{"us-isof-", "aws-iso-f"},
+	{"us-gov-", "aws-us-gov"},
+	{"cn-", "aws-cn"},
+}
+
+// RegionPartition returns the partition region belongs to, e.g. aws-us-gov
+// for us-gov-west-1. Regions of no other known partition are in aws.
+func RegionPartition(region string) string {
+	for _, rp := range regionPartitions {
+		if strings.HasPrefix(region, rp.prefix) {
+			return rp.partition
+		}
+	}
+	return "aws"
+}
+
+// regionalARN returns the ARN of the parameter at path in the given region
+// of the caller's account.
+func regionalARN(region, path string) string {
+	return "arn:" + RegionPartition(region) + ":ssm:" + region + "::parameter" + path
+}
+
+// parseSSMARN returns the partition, region and account of an SSM parameter
+// ARN, or false if name is not one.
+func parseSSMARN(name string) (partition, region, account string, ok bool) {
+	m := ssmARN.FindStringSubmatch(name)
+	if m == nil {
+		return "", "", "", false
+	}
+	return m[1], m[2], m[3], true
+}
 
 // stripARNPrefix removes the SSM ARN prefix from a parameter path, returning the plain path.
 // If the path does not contain an ARN prefix, it is returned unchanged.
@@ -25,6 +72,16 @@ func ceildiv(n, d int) int {
 	return min(n, (n-1)/d+1)
 }
 
+// sortedKeys returns the keys of m in sorted order.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 // merge copies values from srcs to dest.
 func merge(dest map[string]string, srcs []map[string]string) {
 	for _, src := range srcs {
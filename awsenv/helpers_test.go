@@ -100,6 +100,21 @@ func TestStripARNPrefix(t *testing.T) {
 			input: "arn:aws:ssm:us-east-1:123456789012:parameter/my/param/path",
 			want:  "/my/param/path",
 		},
+		{
+			name:  "govcloud_arn",
+			input: "arn:aws-us-gov:ssm:us-gov-west-1:123456789012:parameter/my/param/path",
+			want:  "/my/param/path",
+		},
+		{
+			name:  "china_arn",
+			input: "arn:aws-cn:ssm:cn-north-1:123456789012:parameter/my/param/path",
+			want:  "/my/param/path",
+		},
+		{
+			name:  "iso_arn",
+			input: "arn:aws-iso-b:ssm:us-isob-east-1:123456789012:parameter/my/param/path",
+			want:  "/my/param/path",
+		},
 		{
 			name:  "empty_string",
 			input: "",
@@ -119,6 +134,30 @@ func TestStripARNPrefix(t *testing.T) {
 	}
 }
 
+func TestRegionPartition(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		region string
+		want   string
+	}{
+		{"us-east-1", "aws"},
+		{"eu-west-1", "aws"},
+		{"us-gov-west-1", "aws-us-gov"},
+		{"cn-north-1", "aws-cn"},
+		{"us-iso-east-1", "aws-iso"},
+		{"us-isob-east-1", "aws-iso-b"},
+		{"eu-isoe-west-1", "aws-iso-e"},
+		{"us-isof-south-1", "aws-iso-f"},
+	}
+
+	for _, test := range tests {
+		got := RegionPartition(test.region)
+		if got != test.want {
+			t.Errorf("RegionPartition(%q) = %q, want %q", test.region, got, test.want)
+		}
+	}
+}
+
 func TestChunk(t *testing.T) {
 	tests := []struct {
 		size  int
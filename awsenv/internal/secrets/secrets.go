@@ -0,0 +1,46 @@
+// Package secrets holds what the Secrets Manager getters of awsenv/v1 and
+// awsenv/v2 have in common.
+package secrets
+
+// ErrCodeNotFound is the error code BatchGetSecretValue and GetSecretValue
+// report for a secret that does not exist.
+const ErrCodeNotFound = "ResourceNotFoundException"
+
+// BatchUnsupported reports whether a BatchGetSecretValue call that failed
+// with the given error code cannot be used at all, because the caller is not
+// allowed to make it or the endpoint does not implement it. Secrets are then
+// fetched one at a time with GetSecretValue.
+func BatchUnsupported(code string) bool {
+	switch code {
+	case "AccessDeniedException", "UnknownOperationException", "InvalidAction":
+		return true
+	}
+	return false
+}
+
+// Requested returns the set of names passed to GetParams.
+func Requested(names []string) map[string]bool {
+	requested := make(map[string]bool, len(names))
+	for _, name := range names {
+		requested[name] = true
+	}
+	return requested
+}
+
+// Key returns whichever of name or arn was used to request the secret, so
+// that results can be matched up with the names passed to GetParams.
+func Key(requested map[string]bool, name, arn string) string {
+	if !requested[name] && requested[arn] {
+		return arn
+	}
+	return name
+}
+
+// Value returns the string form of a secret, which is stored either as a
+// string or as binary data.
+func Value(s *string, b []byte) string {
+	if s != nil {
+		return *s
+	}
+	return string(b)
+}
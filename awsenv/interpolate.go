@@ -0,0 +1,147 @@
+package awsenv
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// template is text with references embedded in it, each delimited as
+// ${<prefix><reference>}. Any number of references may appear anywhere in
+// the text. The literal text "${<prefix>" is written as "$${<prefix>".
+//
+//	example: `postgres://app:${awsenv:/prod/db/pass}@${awsenv:/prod/db/host}:5432/app`
+type template struct {
+	prefix string
+	parts  []templatePart
+}
+
+// templatePart is either literal text or an embedded reference.
+type templatePart struct {
+	// text is the literal text or, for a reference, the raw reference.
+	text string
+	// ref is the parsed reference, or nil for literal text.
+	ref *reference
+	// err is the error parsing the raw reference, if any.
+	err error
+}
+
+// isTemplate reports whether s embeds any references with the given prefix.
+func isTemplate(s, prefix string) bool {
+	return strings.Contains(s, "${"+prefix)
+}
+
+// parseTemplate splits s into literal text and the references embedded in
+// it. References that cannot be parsed are kept, with their error, so that
+// each can be reported by render.
+func parseTemplate(s, prefix string) (template, error) {
+	open := "${" + prefix
+	tmpl := template{prefix: prefix}
+
+	var lit strings.Builder
+	for {
+		idx := strings.Index(s, open)
+		if idx < 0 {
+			lit.WriteString(s)
+			break
+		}
+
+		if idx > 0 && s[idx-1] == '$' {
+			// escaped: "$${<prefix>" is the literal "${<prefix>"
+			lit.WriteString(s[:idx-1])
+			lit.WriteString(open)
+			s = s[idx+len(open):]
+			continue
+		}
+
+		lit.WriteString(s[:idx])
+		s = s[idx+len(open):]
+
+		end := referenceEnd(s)
+		if end < 0 {
+			return tmpl, errors.Errorf("unterminated reference %q", open+s)
+		}
+
+		if lit.Len() > 0 {
+			tmpl.parts = append(tmpl.parts, templatePart{text: lit.String()})
+			lit.Reset()
+		}
+
+		raw := s[:end]
+		ref, err := parseReference(raw)
+		tmpl.parts = append(tmpl.parts, templatePart{text: raw, ref: &ref, err: err})
+
+		s = s[end+1:]
+	}
+
+	if lit.Len() > 0 {
+		tmpl.parts = append(tmpl.parts, templatePart{text: lit.String()})
+	}
+
+	return tmpl, nil
+}
+
+// template parses s like parseTemplate, and qualifies each reference in it
+// as set in o.
+func (o options) template(s, prefix string) (template, error) {
+	tmpl, err := parseTemplate(s, prefix)
+	if err != nil {
+		return tmpl, err
+	}
+
+	for i, part := range tmpl.parts {
+		if part.ref == nil || part.err != nil {
+			continue
+		}
+		ref, err := o.qualify(*part.ref)
+		tmpl.parts[i].ref, tmpl.parts[i].err = &ref, err
+	}
+	return tmpl, nil
+}
+
+// paths returns the paths of the valid references in tmpl.
+func (tmpl template) paths() []string {
+	var paths []string
+	for _, part := range tmpl.parts {
+		if part.ref != nil && part.err == nil {
+			paths = append(paths, part.ref.paths()...)
+		}
+	}
+	return paths
+}
+
+// render replaces each reference in tmpl with its value from vals, the
+// results of fetch, using the transforms set in o as well as the built-in
+// ones. References that cannot be resolved are added to rerr, with the
+// given source, and left in the result as they were written. It reports
+// whether every reference was resolved.
+func (tmpl template) render(vals map[string]string, o options, source string, rerr *ResolveError) (string, bool) {
+	var b strings.Builder
+	ok := true
+
+	for _, part := range tmpl.parts {
+		if part.ref == nil {
+			b.WriteString(part.text)
+			continue
+		}
+
+		if part.err != nil {
+			rerr.add(source, part.text, part.err)
+			b.WriteString("${" + tmpl.prefix + part.text + "}")
+			ok = false
+			continue
+		}
+
+		val, err := part.ref.resolve(vals, o.transforms)
+		if err != nil {
+			rerr.add(source, part.ref.path, err)
+			b.WriteString("${" + tmpl.prefix + part.text + "}")
+			ok = false
+			continue
+		}
+		o.searched(source, *part.ref, vals)
+		b.WriteString(val)
+	}
+
+	return b.String(), ok
+}
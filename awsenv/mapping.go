@@ -0,0 +1,156 @@
+package awsenv
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// mappingEntry is a single entry of a mapping file: either the reference
+// itself, or the parameter path along with options.
+type mappingEntry struct {
+	Path      string     `yaml:"path"`
+	Field     string     `yaml:"field"`
+	Optional  bool       `yaml:"optional"`
+	Default   *string    `yaml:"default"`
+	Transform transforms `yaml:"transform"`
+	Region    string     `yaml:"region"`
+}
+
+// transforms are the transforms of a mapping entry, given as a list or as
+// a single string.
+type transforms []string
+
+func (t *transforms) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*t = transforms{node.Value}
+		return nil
+	}
+	return node.Decode((*[]string)(t))
+}
+
+func (e *mappingEntry) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		e.Path = node.Value
+		return nil
+	}
+
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i < len(node.Content); i += 2 {
+			switch key := node.Content[i].Value; key {
+			case "path", "field", "optional", "default", "transform", "region":
+			default:
+				return errors.Errorf("line %d: unknown option %q", node.Content[i].Line, key)
+			}
+		}
+	}
+
+	type plain mappingEntry
+	return node.Decode((*plain)(e))
+}
+
+// reference returns the entry as a reference, as it would follow the
+// prefix in an env var.
+func (e mappingEntry) reference() string {
+	ref := e.Path
+	if e.Field != "" {
+		ref += "#" + e.Field
+	}
+
+	opts := url.Values{}
+	if e.Optional {
+		opts.Set("optional", "true")
+	}
+	if e.Default != nil {
+		opts.Set("default", *e.Default)
+	}
+	if e.Region != "" {
+		opts.Set("region", e.Region)
+	}
+	if len(opts) > 0 {
+		ref += "?" + opts.Encode()
+	}
+
+	for _, t := range e.Transform {
+		ref += "|" + t
+	}
+
+	return ref
+}
+
+// ReadMapping reads a mapping file, as parsed by ParseMapping.
+func ReadMapping(fileName string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, errors.Wrap(err, "awsenv: cannot read mapping file")
+	}
+
+	mapping, err := ParseMapping(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "awsenv: %s", fileName)
+	}
+	return mapping, nil
+}
+
+// ParseMapping parses a YAML document mapping env var names to parameters,
+// for use with WithMapping. Each parameter is given either as a reference,
+// exactly as it would follow the prefix in an env var, or with its options
+// spelled out:
+//
+//	DB_PASSWORD: /prod/app/db/pass
+//	API_KEY:
+//	  path: /prod/app/api
+//	  field: key
+//	  optional: true
+//	  default: none
+//	  transform: [trim, base64decode]
+//	  region: us-west-2
+//
+// The result maps each env var name to its reference.
+func ParseMapping(data []byte) (map[string]string, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+
+	var entries map[string]mappingEntry
+	if err := dec.Decode(&entries); err != nil {
+		if errors.Is(err, io.EOF) {
+			return map[string]string{}, nil
+		}
+		return nil, errors.Wrap(err, "invalid mapping")
+	}
+
+	mapping := make(map[string]string, len(entries))
+	for _, name := range sortedEntryNames(entries) {
+		entry := entries[name]
+		if !envNamePattern.MatchString(name) {
+			return nil, errors.Errorf("invalid env var name %q", name)
+		}
+		if strings.TrimSpace(entry.Path) == "" {
+			return nil, errors.Errorf("%s: missing parameter path", name)
+		}
+
+		ref := entry.reference()
+		if _, err := (options{}).reference(ref); err != nil {
+			return nil, errors.Wrap(err, name)
+		}
+		mapping[name] = ref
+	}
+
+	return mapping, nil
+}
+
+// sortedEntryNames returns the names of entries in sorted order, so that
+// errors are reported consistently.
+func sortedEntryNames(entries map[string]mappingEntry) []string {
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
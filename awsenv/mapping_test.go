@@ -0,0 +1,176 @@
+package awsenv
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMapping(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name  string
+		input string
+		want  map[string]string
+	}{
+		{
+			name:  "empty",
+			input: "",
+			want:  map[string]string{},
+		},
+		{
+			name: "references",
+			input: `
+DB_PASSWORD: /prod/app/db/pass
+DB_USER: /prod/app/db#user
+API_KEY: sm:prod/api-key|trim
+`,
+			want: map[string]string{
+				"DB_PASSWORD": "/prod/app/db/pass",
+				"DB_USER":     "/prod/app/db#user",
+				"API_KEY":     "sm:prod/api-key|trim",
+			},
+		},
+		{
+			name: "options",
+			input: `
+TLS_KEY:
+  path: /prod/app/tls
+  field: key
+  optional: true
+  transform: [base64decode, trim]
+  region: us-west-2
+LOG_LEVEL:
+  path: /prod/app/log-level
+  default: "info & more|#?"
+TOKEN:
+  path: /prod/app/token
+  transform: json-field:/a/b
+`,
+			want: map[string]string{
+				"TLS_KEY":   "/prod/app/tls#key?optional=true&region=us-west-2|base64decode|trim",
+				"LOG_LEVEL": "/prod/app/log-level?default=info+%26+more%7C%23%3F",
+				"TOKEN":     "/prod/app/token|json-field:/a/b",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := ParseMapping([]byte(test.input))
+			require.NoError(t, err)
+			require.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestParseMapping_invalid(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{
+			name:    "not_a_mapping",
+			input:   "- /prod/app/db/pass",
+			wantErr: "invalid mapping: yaml: unmarshal errors:\n  line 1: cannot unmarshal !!seq into map[string]awsenv.mappingEntry",
+		},
+		{
+			name:    "invalid_name",
+			input:   "DB-PASSWORD: /prod/app/db/pass",
+			wantErr: `invalid env var name "DB-PASSWORD"`,
+		},
+		{
+			name:    "missing_path",
+			input:   "DB_PASSWORD: {optional: true}",
+			wantErr: "DB_PASSWORD: missing parameter path",
+		},
+		{
+			name:    "unknown_option",
+			input:   "DB_PASSWORD:\n  path: /prod/app/db/pass\n  optinal: true",
+			wantErr: `invalid mapping: line 3: unknown option "optinal"`,
+		},
+		{
+			name:    "invalid_reference",
+			input:   "DB_PASSWORD: /prod/app/db/pass?bogus",
+			wantErr: `DB_PASSWORD: invalid reference: unknown option "bogus"`,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := ParseMapping([]byte(test.input))
+			require.EqualError(t, err, test.wantErr)
+		})
+	}
+}
+
+func TestReadMapping(t *testing.T) {
+	t.Parallel()
+	fileName := filepath.Join(t.TempDir(), ".aws-env.yaml")
+	require.NoError(t, ioutil.WriteFile(fileName, []byte("DB_PASSWORD: /prod/app/db/pass\n"), 0600))
+
+	got, err := ReadMapping(fileName)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"DB_PASSWORD": "/prod/app/db/pass"}, got)
+
+	_, err = ReadMapping(filepath.Join(t.TempDir(), "missing.yaml"))
+	require.Error(t, err)
+}
+
+func TestReplacer_ReplaceAll_Mapping(t *testing.T) {
+	// Not parallel: this test mutates global environ/setenv via fakeEnv.install()
+	origEnviron := environ
+	origSetenv := setenv
+	t.Cleanup(func() {
+		environ = origEnviron
+		setenv = origSetenv
+	})
+
+	env := fakeEnv{
+		"DB_USER":  "awsenv:/prod/app/db-user",
+		"LOG_MODE": "local",
+	}
+	env.install()
+
+	mapping, err := ParseMapping([]byte(`
+DB_PASSWORD: /prod/app/db#pass
+LOG_MODE: /prod/app/log-mode
+LOG_LEVEL:
+  path: /prod/app/log-level
+  default: info
+MISSING: /prod/app/missing
+`))
+	require.NoError(t, err)
+
+	params := &recordingParamStore{
+		lenientParamStore: lenientParamStore{
+			"/prod/app/db":       `{"pass":"s3cr3t"}`,
+			"/prod/app/db-user":  "app",
+			"/prod/app/log-mode": "json",
+		},
+	}
+
+	r := NewReplacer(DefaultPrefix, params, WithMapping(mapping))
+	err = r.ReplaceAll(context.Background())
+	require.EqualError(t, err, `awsenv: MISSING: "/prod/app/missing": param not found`)
+
+	want := fakeEnv{
+		"DB_USER":     "app",
+		"DB_PASSWORD": "s3cr3t",
+		"LOG_MODE":    "local",
+		"LOG_LEVEL":   "info",
+	}
+	require.Equal(t, want, env)
+
+	// mapped and discovered references are fetched together
+	require.Equal(t, [][]string{{"/prod/app/db", "/prod/app/db-user", "/prod/app/log-level", "/prod/app/missing"}}, params.requests)
+}
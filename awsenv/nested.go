@@ -0,0 +1,279 @@
+package awsenv
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultMaxDepth is how many references deep a reference found inside a
+// parameter value is followed, unless changed by WithMaxDepth.
+const DefaultMaxDepth = 8
+
+// fetchNested retrieves the values of paths like fetch, then resolves the
+// references found inside those values: a value beginning with prefix, or
+// embedding references delimited as ${<prefix><reference>}. The params they
+// refer to are fetched a level at a time, in batches, up to maxDepth levels
+// deep, as set in opts. A maxDepth <= 0 disables nested resolution.
+//
+// Names that could not be resolved are returned with the reason, replacing
+// the ErrParamNotFound of any reference to them.
+func fetchNested(ctx context.Context, ssm ParamsGetter, paths []string, prefix string, opts options) (map[string]string, map[string]error, error) {
+	maxDepth := opts.maxDepth
+
+	vals, err := fetch(ctx, ssm, paths)
+	invalid, err := invalidNames(err)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reasons := make(map[string]error, len(invalid))
+	for name := range invalid {
+		reasons[name] = ErrParamInvalid
+	}
+
+	if maxDepth <= 0 {
+		return vals, reasons, nil
+	}
+
+	seen := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		seen[path] = true
+	}
+
+	for depth := 0; depth < maxDepth; depth++ {
+		var next []string
+		for _, key := range sortedKeys(vals) {
+			for _, path := range opts.nestedPaths(vals[key], prefix) {
+				if !seen[path] {
+					seen[path] = true
+					next = append(next, path)
+				}
+			}
+		}
+		if len(next) == 0 {
+			break
+		}
+
+		more, err := fetch(ctx, ssm, next)
+		invalid, err := invalidNames(err)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for key, val := range more {
+			if _, ok := vals[key]; !ok {
+				vals[key] = val
+			}
+		}
+		for name := range invalid {
+			reasons[name] = ErrParamInvalid
+		}
+	}
+
+	n := nestedResolver{
+		options:  opts,
+		prefix:   prefix,
+		raw:      vals,
+		reasons:  reasons,
+		resolved: make(map[string]string, len(vals)),
+		failed:   make(map[string]error),
+	}
+
+	for _, key := range sortedKeys(vals) {
+		_, err := n.resolveKey(key)
+		if err != nil {
+			reasons[key] = err
+		}
+	}
+
+	return n.resolved, reasons, nil
+}
+
+// nestedPaths returns the paths of the references in val, if any.
+func (o options) nestedPaths(val, prefix string) []string {
+	if strings.HasPrefix(val, prefix) {
+		ref, err := o.reference(strings.TrimPrefix(val, prefix))
+		if err != nil {
+			// reported by nestedResolver
+			return nil
+		}
+		return ref.paths()
+	}
+
+	if isTemplate(val, prefix) {
+		tmpl, err := o.template(val, prefix)
+		if err != nil {
+			// reported by nestedResolver
+			return nil
+		}
+		return tmpl.paths()
+	}
+
+	return nil
+}
+
+// nestedResolver replaces references inside fetched values with the values
+// they refer to, following them depth first to detect cycles.
+type nestedResolver struct {
+	options
+	prefix string
+
+	// raw holds the fetched values, and reasons why other names could not
+	// be fetched.
+	raw     map[string]string
+	reasons map[string]error
+
+	resolved map[string]string
+	failed   map[string]error
+
+	// stack holds the keys being resolved, outermost first.
+	stack []string
+}
+
+// resolveKey returns the fetched value under key, with any references in
+// it resolved.
+func (n *nestedResolver) resolveKey(key string) (string, error) {
+	if val, ok := n.resolved[key]; ok {
+		return val, nil
+	}
+	if err, ok := n.failed[key]; ok {
+		return "", err
+	}
+
+	for i, k := range n.stack {
+		if k == key {
+			loop := append(append([]string(nil), n.stack[i:]...), key)
+			return "", errors.Errorf("reference cycle: %s", strings.Join(loop, " -> "))
+		}
+	}
+
+	raw := n.raw[key]
+	if len(n.stack) >= n.maxDepth && n.nestedPaths(raw, n.prefix) != nil {
+		return "", &depthError{
+			maxDepth: n.maxDepth,
+			chain:    append(append([]string(nil), n.stack...), key),
+		}
+	}
+
+	n.stack = append(n.stack, key)
+	val, err := n.resolveValue(raw)
+	n.stack = n.stack[:len(n.stack)-1]
+
+	if err != nil {
+		// whether the depth limit is reached depends on where resolution
+		// started, so only other failures are remembered
+		var derr *depthError
+		if !errors.As(err, &derr) {
+			n.failed[key] = err
+		}
+		return "", err
+	}
+
+	n.resolved[key] = val
+	return val, nil
+}
+
+// resolveValue resolves the references in val, if any.
+func (n *nestedResolver) resolveValue(val string) (string, error) {
+	if strings.HasPrefix(val, n.prefix) {
+		raw := strings.TrimPrefix(val, n.prefix)
+		ref, err := n.reference(raw)
+		if err != nil {
+			return "", errors.Wrapf(err, "via %q", raw)
+		}
+
+		vals, err := n.targets([]reference{ref})
+		if err != nil {
+			return "", err
+		}
+
+		val, err := ref.resolve(vals, n.transforms)
+		if err != nil {
+			return "", errors.Wrapf(n.reason(ref, err), "via %q", ref.path)
+		}
+		n.searched(n.stack[len(n.stack)-1], ref, vals)
+		return val, nil
+	}
+
+	if isTemplate(val, n.prefix) {
+		tmpl, err := n.template(val, n.prefix)
+		if err != nil {
+			return "", err
+		}
+
+		var refs []reference
+		for _, part := range tmpl.parts {
+			if part.ref != nil && part.err == nil {
+				refs = append(refs, *part.ref)
+			}
+		}
+
+		vals, err := n.targets(refs)
+		if err != nil {
+			return "", err
+		}
+
+		var rerr ResolveError
+		val, ok := tmpl.render(vals, n.options, n.stack[len(n.stack)-1], &rerr)
+		if !ok {
+			perr := rerr.Errors[0]
+			return "", errors.Wrapf(n.reason(reference{path: perr.Name}, perr.Err), "via %q", perr.Name)
+		}
+		return val, nil
+	}
+
+	return val, nil
+}
+
+// targets resolves the values refs refer to, keyed as in the results of
+// fetch. Values that were not fetched are left out.
+func (n *nestedResolver) targets(refs []reference) (map[string]string, error) {
+	vals := make(map[string]string, len(refs))
+	for _, ref := range refs {
+		key, ok := n.key(ref)
+		if !ok {
+			continue
+		}
+
+		val, err := n.resolveKey(key)
+		if err != nil {
+			if ref.optional {
+				continue
+			}
+			return nil, err
+		}
+		vals[key] = val
+	}
+	return vals, nil
+}
+
+// key returns the key under which the value for ref was fetched.
+func (n *nestedResolver) key(ref reference) (string, bool) {
+	_, key, ok := ref.lookup(n.raw)
+	return key, ok
+}
+
+// reason replaces ErrParamNotFound with the reason ref could not be fetched,
+// if known.
+func (n *nestedResolver) reason(ref reference, err error) error {
+	if err != ErrParamNotFound {
+		return err
+	}
+	if reason, ok := n.reasons[ref.path]; ok {
+		return reason
+	}
+	return err
+}
+
+// depthError reports a chain of references longer than the depth limit.
+type depthError struct {
+	maxDepth int
+	chain    []string
+}
+
+func (e *depthError) Error() string {
+	return fmt.Sprintf("references nested more than %d deep: %s", e.maxDepth, strings.Join(e.chain, " -> "))
+}
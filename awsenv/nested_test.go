@@ -0,0 +1,189 @@
+package awsenv
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// recordingParamStore is a lenientParamStore that records every request.
+type recordingParamStore struct {
+	lenientParamStore
+
+	mu       sync.Mutex
+	requests [][]string
+}
+
+func (m *recordingParamStore) GetParams(ctx context.Context, paths []string) (map[string]string, error) {
+	m.mu.Lock()
+	req := append([]string(nil), paths...)
+	sort.Strings(req)
+	m.requests = append(m.requests, req)
+	m.mu.Unlock()
+	return m.lenientParamStore.GetParams(ctx, paths)
+}
+
+func TestFetchNested(t *testing.T) {
+	t.Parallel()
+	params := lenientParamStore{
+		"/app/db/pass":    "awsenv:/shared/db/pass",
+		"/app/db/user":    "awsenv:/shared/db#user",
+		"/app/db/url":     "postgres://${awsenv:/shared/db#user}:${awsenv:/app/db/pass}@db/app",
+		"/app/chain":      "awsenv:/app/db/pass",
+		"/app/opt":        "awsenv:/shared/missing?default=fallback",
+		"/app/plain":      "plain",
+		"/app/missing":    "awsenv:/shared/missing",
+		"/app/cycle/a":    "awsenv:/app/cycle/b",
+		"/app/cycle/b":    "awsenv:/app/cycle/a",
+		"/app/deep/1":     "awsenv:/app/deep/2",
+		"/app/deep/2":     "awsenv:/app/deep/3",
+		"/app/deep/3":     "awsenv:/app/deep/4",
+		"/app/deep/4":     "bottom",
+		"/shared/db/pass": "s3cr3t",
+		"/shared/db":      `{"user":"app"}`,
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "pointer",
+			path: "/app/db/pass",
+			want: "s3cr3t",
+		},
+		{
+			name: "pointer_with_field",
+			path: "/app/db/user",
+			want: "app",
+		},
+		{
+			name: "interpolated",
+			path: "/app/db/url",
+			want: "postgres://app:s3cr3t@db/app",
+		},
+		{
+			name: "chain",
+			path: "/app/chain",
+			want: "s3cr3t",
+		},
+		{
+			name: "default",
+			path: "/app/opt",
+			want: "fallback",
+		},
+		{
+			name: "plain",
+			path: "/app/plain",
+			want: "plain",
+		},
+		{
+			name:    "missing",
+			path:    "/app/missing",
+			wantErr: `via "/shared/missing": param not found`,
+		},
+		{
+			name:    "cycle",
+			path:    "/app/cycle/a",
+			wantErr: "reference cycle: /app/cycle/a -> /app/cycle/b -> /app/cycle/a",
+		},
+		{
+			name:    "too_deep",
+			path:    "/app/deep/1",
+			wantErr: "references nested more than 2 deep: /app/deep/1 -> /app/deep/2 -> /app/deep/3",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			vals, reasons, err := fetchNested(context.Background(), params, []string{test.path}, DefaultPrefix, options{maxDepth: 2})
+			require.NoError(t, err)
+
+			if test.wantErr != "" {
+				require.NotContains(t, vals, test.path)
+				require.EqualError(t, reasons[test.path], test.wantErr)
+				return
+			}
+			require.Equal(t, test.want, vals[test.path])
+			require.NotContains(t, reasons, test.path)
+		})
+	}
+}
+
+func TestFetchNested_batches(t *testing.T) {
+	t.Parallel()
+	params := &recordingParamStore{
+		lenientParamStore: lenientParamStore{
+			"/a": "awsenv:/shared/1",
+			"/b": "${awsenv:/shared/1}-${awsenv:/shared/2}",
+			"/c": "awsenv:/a",
+
+			"/shared/1": "awsenv:/shared/3",
+			"/shared/2": "two",
+			"/shared/3": "three",
+		},
+	}
+
+	vals, reasons, err := fetchNested(context.Background(), params, []string{"/a", "/b", "/c"}, DefaultPrefix, options{maxDepth: DefaultMaxDepth})
+	require.NoError(t, err)
+	require.Empty(t, reasons)
+
+	require.Equal(t, "three", vals["/a"])
+	require.Equal(t, "three-two", vals["/b"])
+	require.Equal(t, "three", vals["/c"])
+
+	// one request per level, without fetching anything twice
+	want := [][]string{
+		{"/a", "/b", "/c"},
+		{"/shared/1", "/shared/2"},
+		{"/shared/3"},
+	}
+	require.Equal(t, want, params.requests)
+}
+
+func TestFetchNested_disabled(t *testing.T) {
+	t.Parallel()
+	params := lenientParamStore{"/a": "awsenv:/b", "/b": "b"}
+
+	vals, _, err := fetchNested(context.Background(), params, []string{"/a"}, DefaultPrefix, options{})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"/a": "awsenv:/b"}, vals)
+}
+
+func TestReplacer_ReplaceAll_Nested(t *testing.T) {
+	// Not parallel: this test mutates global environ/setenv via fakeEnv.install()
+	origEnviron := environ
+	origSetenv := setenv
+	t.Cleanup(func() {
+		environ = origEnviron
+		setenv = origSetenv
+	})
+
+	env := fakeEnv{
+		"DB_PASS": "awsenv:/app/db/pass",
+		"LOOP":    "awsenv:/app/loop",
+	}
+	env.install()
+
+	params := lenientParamStore{
+		"/app/db/pass":    "awsenv:/shared/db/pass",
+		"/shared/db/pass": "s3cr3t",
+		"/app/loop":       "awsenv:/app/loop",
+	}
+
+	err := NewReplacer(DefaultPrefix, params).ReplaceAll(context.Background())
+	require.EqualError(t, err, `awsenv: LOOP: "/app/loop": reference cycle: /app/loop -> /app/loop`)
+
+	want := fakeEnv{
+		"DB_PASS": "s3cr3t",
+		"LOOP":    "awsenv:/app/loop",
+	}
+	require.Equal(t, want, env)
+}
@@ -0,0 +1,162 @@
+package awsenv
+
+import "strings"
+
+// Option configures optional behavior of a Replacer or FileReplacer.
+// Options that do not apply to the value they are passed to are ignored.
+type Option func(*options)
+
+type options struct {
+	expandPrefix   string
+	expandOverride bool
+
+	recursive  bool
+	nameMapper NameMapper
+
+	maxDepth   int
+	transforms map[string]Transform
+
+	mapping     map[string]string
+	vars        map[string]string
+	searchPaths []string
+	searchHook  SearchHook
+
+	schemes schemeRegistry
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		expandPrefix: DefaultExpandPrefix,
+		maxDepth:     DefaultMaxDepth,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// schemeRegistry is implemented by a ParamsGetter, such as a Router, that
+// sends names beginning with a registered scheme to other backends.
+type schemeRegistry interface {
+	HasScheme(scheme string) bool
+}
+
+// withGetter returns o set up for references fetched from getter.
+func (o options) withGetter(getter ParamsGetter) options {
+	o.schemes, _ = getter.(schemeRegistry)
+	return o
+}
+
+// WithExpandPrefix sets the value prefix marking env vars whose parameter
+// should be expanded into many env vars, replacing DefaultExpandPrefix.
+// An empty prefix disables expansion. It applies only to a Replacer.
+func WithExpandPrefix(prefix string) Option {
+	return func(o *options) {
+		o.expandPrefix = prefix
+	}
+}
+
+// WithExpandOverride controls whether expanded env vars overwrite env vars
+// that are already set. By default, env vars that are already set are left
+// as they are. It applies to a Replacer and a PathImporter.
+func WithExpandOverride(override bool) Option {
+	return func(o *options) {
+		o.expandOverride = override
+	}
+}
+
+// WithRecursive controls whether a PathImporter also imports parameters
+// nested below the immediate children of its path.
+func WithRecursive(recursive bool) Option {
+	return func(o *options) {
+		o.recursive = recursive
+	}
+}
+
+// WithNameMapper sets how a PathImporter derives env var names from
+// parameter names, replacing LastSegmentName.
+func WithNameMapper(mapper NameMapper) Option {
+	return func(o *options) {
+		o.nameMapper = mapper
+	}
+}
+
+// WithMaxDepth sets how many references deep a reference found inside a
+// parameter value is followed, replacing DefaultMaxDepth. A depth <= 0
+// leaves such values as they are. It applies to a Replacer and a
+// FileReplacer.
+func WithMaxDepth(depth int) Option {
+	return func(o *options) {
+		o.maxDepth = depth
+	}
+}
+
+// WithTransform registers fn as the transform called name, which references
+// can then use after a "|", e.g. `awsenv:/path|name`. It replaces any
+// built-in transform of the same name. It applies to a Replacer and a
+// FileReplacer.
+func WithTransform(name string, fn Transform) Option {
+	return func(o *options) {
+		if o.transforms == nil {
+			o.transforms = make(map[string]Transform)
+		}
+		o.transforms[name] = fn
+	}
+}
+
+// WithMapping adds env vars resolved from the given references, as parsed
+// by ParseMapping, alongside those found in the environment. Env vars that
+// are already set take precedence over the mapping. It applies only to a
+// Replacer.
+func WithMapping(mapping map[string]string) Option {
+	return func(o *options) {
+		o.mapping = mapping
+	}
+}
+
+// WithVars sets values for ${NAME} placeholders in reference paths, e.g.
+// `/${STAGE}/my-app/db`. Placeholders not in vars are taken from the
+// environment. It applies to a Replacer and a FileReplacer.
+func WithVars(vars map[string]string) Option {
+	return func(o *options) {
+		o.vars = vars
+	}
+}
+
+// WithBasePath sets the path that relative references, e.g. `db/pass`, are
+// resolved against, e.g. `/prod/my-app` for `/prod/my-app/db/pass`.
+// References to absolute paths or ARNs are not affected. It applies to a
+// Replacer and a FileReplacer, and replaces any WithSearchPaths.
+func WithBasePath(path string) Option {
+	if path == "" {
+		return WithSearchPaths()
+	}
+	return WithSearchPaths(path)
+}
+
+// WithSearchPaths sets the paths that relative references are looked up
+// under, in order, e.g. `/prod/my-app`, `/prod/shared` and `/global`. The
+// value is taken from the first path where the parameter exists, and every
+// candidate is fetched in the same batched requests. It applies to a
+// Replacer and a FileReplacer, and replaces any WithBasePath.
+func WithSearchPaths(paths ...string) Option {
+	return func(o *options) {
+		o.searchPaths = make([]string, len(paths))
+		for i, path := range paths {
+			o.searchPaths[i] = strings.TrimSuffix(path, "/")
+		}
+	}
+}
+
+// SearchHook is called with the full path that a relative reference was
+// resolved from, and the env var or file line holding the reference, when
+// several search paths are set.
+type SearchHook func(source, path string)
+
+// WithSearchHook sets a SearchHook, e.g. to log which search path each
+// value came from. It applies to a Replacer and a FileReplacer.
+func WithSearchHook(hook SearchHook) Option {
+	return func(o *options) {
+		o.searchHook = hook
+	}
+}
@@ -0,0 +1,142 @@
+package awsenv
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// PathGetter represents a data source that can list every parameter under
+// a path, returning a map of parameter names to values.
+type PathGetter interface {
+	GetParamsByPath(ctx context.Context, path string, recursive bool) (map[string]string, error)
+}
+
+// NameMapper converts the name of a parameter found under root into an env
+// var name.
+type NameMapper func(root, name string) string
+
+// LastSegmentName uses the last segment of the parameter name, upper-cased,
+// with characters not valid in env var names turned into underscores.
+//
+//	example: `/prod/my-app/db/pass-word` becomes `PASS_WORD`
+func LastSegmentName(_, name string) string {
+	return envName(name[strings.LastIndex(name, "/")+1:])
+}
+
+// RelativePathName uses the parameter name relative to root, upper-cased,
+// with "/" and other characters not valid in env var names turned into
+// underscores. It is useful with recursive imports.
+//
+//	example: `/prod/my-app/db/pass-word` under `/prod/my-app` becomes `DB_PASS_WORD`
+func RelativePathName(root, name string) string {
+	name = strings.TrimPrefix(name, strings.TrimSuffix(root, "/")+"/")
+	return envName(name)
+}
+
+// envName upper-cases s and turns characters not valid in env var names into
+// underscores.
+func envName(s string) string {
+	name := []byte(strings.ToUpper(s))
+	for i, c := range name {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') && c != '_' {
+			name[i] = '_'
+		}
+	}
+
+	if len(name) > 0 && name[0] >= '0' && name[0] <= '9' {
+		return "_" + string(name)
+	}
+	return string(name)
+}
+
+// NewPathImporter returns a PathImporter that exports every parameter
+// under path as an env var, using the given PathGetter.
+//
+// NewPathImporter will panic if path is the empty string.
+func NewPathImporter(path string, getter PathGetter, opts ...Option) *PathImporter {
+	if path == "" {
+		panic("awsenv: path must be non-empty")
+	}
+
+	return &PathImporter{
+		getter:  getter,
+		path:    path,
+		options: newOptions(opts),
+	}
+}
+
+// PathImporter handles setting an environment variable for every parameter
+// under a path in AWS Parameter Store. Names are derived from parameter
+// names using the NameMapper set by WithNameMapper, LastSegmentName by
+// default. Env vars that are already set are left as they are, unless
+// WithExpandOverride is used.
+type PathImporter struct {
+	getter PathGetter
+	path   string
+	options
+}
+
+// ReplaceAll sets an env var for every parameter under the path.
+// ReplaceAll will attempt to set as many values as possible.
+func (p *PathImporter) ReplaceAll(ctx context.Context) error {
+	vars, err := p.Replacements(ctx)
+	if err != nil {
+		return err
+	}
+
+	for name, val := range vars {
+		suberr := setenv(name, val)
+		if err == nil && suberr != nil {
+			err = suberr
+		}
+	}
+
+	return err
+}
+
+// MustReplaceAll sets an env var for every parameter under the path generating a panic if something goes wrong.
+func (p *PathImporter) MustReplaceAll(ctx context.Context) {
+	err := p.ReplaceAll(ctx)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Replacements returns a map of environment variable names to values for
+// every parameter under the path.
+func (p *PathImporter) Replacements(ctx context.Context) (map[string]string, error) {
+	params, err := p.getter.GetParamsByPath(ctx, p.path, p.recursive)
+	if err != nil {
+		return nil, err
+	}
+
+	mapName := p.nameMapper
+	if mapName == nil {
+		mapName = LastSegmentName
+	}
+
+	envvars := parseEnvironment(environ())
+	vars := make(map[string]string, len(params))
+	sources := make(map[string]string, len(params))
+
+	for param, val := range params {
+		name := mapName(p.path, param)
+		if !envNamePattern.MatchString(name) {
+			return nil, errors.Errorf("awsenv: invalid env var name %q for param %q", name, param)
+		}
+
+		if source, ok := sources[name]; ok {
+			return nil, errors.Errorf("awsenv: params %q and %q both map to %s", source, param, name)
+		}
+		sources[name] = param
+
+		if _, ok := envvars[name]; ok && !p.expandOverride {
+			continue
+		}
+		vars[name] = val
+	}
+
+	return vars, nil
+}
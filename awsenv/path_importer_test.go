@@ -0,0 +1,152 @@
+package awsenv
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type mockPathGetter func(ctx context.Context, path string, recursive bool) (map[string]string, error)
+
+func (f mockPathGetter) GetParamsByPath(ctx context.Context, path string, recursive bool) (map[string]string, error) {
+	return f(ctx, path, recursive)
+}
+
+func TestNameMappers(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name   string
+		mapper NameMapper
+		root   string
+		param  string
+		want   string
+	}{
+		{"last_segment", LastSegmentName, "/prod/my-app/", "/prod/my-app/db-pass", "DB_PASS"},
+		{"last_segment_nested", LastSegmentName, "/prod/my-app", "/prod/my-app/db/pass.word", "PASS_WORD"},
+		{"last_segment_leading_digit", LastSegmentName, "/prod", "/prod/2fa-key", "_2FA_KEY"},
+		{"relative", RelativePathName, "/prod/my-app/", "/prod/my-app/db/pass-word", "DB_PASS_WORD"},
+		{"relative_no_trailing_slash", RelativePathName, "/prod/my-app", "/prod/my-app/db/pass", "DB_PASS"},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			require.Equal(t, test.want, test.mapper(test.root, test.param))
+		})
+	}
+}
+
+func TestPathImporter_panic(t *testing.T) {
+	t.Parallel()
+	require.Panics(t, func() { NewPathImporter("", nil) })
+}
+
+func TestPathImporter_ReplaceAll(t *testing.T) {
+	// Not parallel: this test mutates global environ/setenv via fakeEnv.install()
+	origEnviron := environ
+	origSetenv := setenv
+	t.Cleanup(func() {
+		environ = origEnviron
+		setenv = origSetenv
+	})
+
+	env := fakeEnv{
+		"DB_HOST": "localhost", // already set, not overwritten
+	}
+	env.install()
+
+	getter := mockPathGetter(func(_ context.Context, path string, recursive bool) (map[string]string, error) {
+		require.Equal(t, "/prod/my-app/", path)
+		require.False(t, recursive)
+		return map[string]string{
+			"/prod/my-app/db-host": "remote",
+			"/prod/my-app/db-pass": "secret",
+		}, nil
+	})
+
+	r := NewPathImporter("/prod/my-app/", getter)
+	err := r.ReplaceAll(context.Background())
+	require.NoError(t, err)
+
+	want := fakeEnv{
+		"DB_HOST": "localhost",
+		"DB_PASS": "secret",
+	}
+	require.Equal(t, want, env)
+}
+
+func TestPathImporter_Replacements_options(t *testing.T) {
+	// Not parallel: this test mutates global environ/setenv via fakeEnv.install()
+	origEnviron := environ
+	origSetenv := setenv
+	t.Cleanup(func() {
+		environ = origEnviron
+		setenv = origSetenv
+	})
+
+	env := fakeEnv{
+		"DB_HOST": "localhost",
+	}
+	env.install()
+
+	getter := mockPathGetter(func(_ context.Context, _ string, recursive bool) (map[string]string, error) {
+		require.True(t, recursive)
+		return map[string]string{
+			"/prod/my-app/db/host": "remote",
+			"/prod/my-app/api/key": "key",
+		}, nil
+	})
+
+	r := NewPathImporter("/prod/my-app", getter,
+		WithRecursive(true),
+		WithNameMapper(RelativePathName),
+		WithExpandOverride(true),
+	)
+	got, err := r.Replacements(context.Background())
+	require.NoError(t, err)
+
+	want := map[string]string{
+		"DB_HOST": "remote",
+		"API_KEY": "key",
+	}
+	require.Equal(t, want, got)
+}
+
+func TestPathImporter_Replacements_errors(t *testing.T) {
+	// Not parallel: this test mutates global environ/setenv via fakeEnv.install()
+	origEnviron := environ
+	origSetenv := setenv
+	t.Cleanup(func() {
+		environ = origEnviron
+		setenv = origSetenv
+	})
+
+	env := fakeEnv{}
+	env.install()
+
+	failing := mockPathGetter(func(context.Context, string, bool) (map[string]string, error) {
+		return nil, errors.New("forced")
+	})
+	_, err := NewPathImporter("/prod", failing).Replacements(context.Background())
+	require.EqualError(t, err, "forced")
+
+	conflicting := mockPathGetter(func(context.Context, string, bool) (map[string]string, error) {
+		return map[string]string{
+			"/prod/a/key": "a",
+			"/prod/b/key": "b",
+		}, nil
+	})
+	_, err = NewPathImporter("/prod", conflicting, WithRecursive(true)).Replacements(context.Background())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "both map to KEY")
+
+	invalid := mockPathGetter(func(context.Context, string, bool) (map[string]string, error) {
+		return map[string]string{"/prod/key": "a"}, nil
+	})
+	mapper := func(_, name string) string { return "not valid" }
+	_, err = NewPathImporter("/prod", invalid, WithNameMapper(mapper)).Replacements(context.Background())
+	require.EqualError(t, err, `awsenv: invalid env var name "not valid" for param "/prod/key"`)
+}
@@ -0,0 +1,137 @@
+package awsenv
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// lookupFunc returns the value of a placeholder, and whether it is defined.
+type lookupFunc func(name string) (string, bool)
+
+// placeholderLookup returns a lookupFunc finding placeholders in vars, as
+// set by WithVars, and then in env.
+func (o options) placeholderLookup(env map[string]string) lookupFunc {
+	return func(name string) (string, bool) {
+		if val, ok := o.vars[name]; ok {
+			return val, true
+		}
+		val, ok := env[name]
+		return val, ok
+	}
+}
+
+// expandPlaceholders replaces each ${NAME} placeholder in a raw reference
+// with the value of NAME.
+//
+//	example: `/${STAGE}/my-app/db` becomes `/prod/my-app/db`
+//
+// The literal text "${" is written "$${".
+func expandPlaceholders(raw string, lookup lookupFunc) (string, error) {
+	if !strings.Contains(raw, "${") {
+		return raw, nil
+	}
+
+	var b strings.Builder
+	for {
+		idx := strings.Index(raw, "${")
+		if idx < 0 {
+			b.WriteString(raw)
+			break
+		}
+
+		if idx > 0 && raw[idx-1] == '$' {
+			// escaped: "$${" is the literal "${"
+			b.WriteString(raw[:idx-1])
+			b.WriteString("${")
+			raw = raw[idx+2:]
+			continue
+		}
+
+		b.WriteString(raw[:idx])
+		raw = raw[idx+2:]
+
+		end := strings.Index(raw, "}")
+		if end < 0 {
+			return "", errors.Errorf("unterminated placeholder %q", "${"+raw)
+		}
+
+		name := raw[:end]
+		if !envNamePattern.MatchString(name) {
+			return "", errors.Errorf("invalid placeholder %q", "${"+name+"}")
+		}
+
+		val, ok := lookup(name)
+		if !ok {
+			return "", errors.Errorf("undefined placeholder ${%s}", name)
+		}
+		b.WriteString(val)
+
+		raw = raw[end+1:]
+	}
+
+	return b.String(), nil
+}
+
+// expandTemplatePlaceholders expands the placeholders in each reference
+// embedded in s as ${<prefix><reference>}, leaving the text around them as
+// it is. If a placeholder cannot be expanded, the raw reference holding it
+// is returned with the error.
+func expandTemplatePlaceholders(s, prefix string, lookup lookupFunc) (expanded, failed string, err error) {
+	open := "${" + prefix
+
+	var b strings.Builder
+	for {
+		idx := strings.Index(s, open)
+		if idx < 0 {
+			b.WriteString(s)
+			break
+		}
+
+		start := idx + len(open)
+		if idx > 0 && s[idx-1] == '$' {
+			// escaped, so kept as it is
+			b.WriteString(s[:start])
+			s = s[start:]
+			continue
+		}
+
+		end := referenceEnd(s[start:])
+		if end < 0 {
+			// reported by parseTemplate
+			b.WriteString(s)
+			break
+		}
+
+		raw, err := expandPlaceholders(s[start:start+end], lookup)
+		if err != nil {
+			return "", s[start : start+end], err
+		}
+
+		b.WriteString(s[:start])
+		b.WriteString(raw)
+		s = s[start+end:]
+	}
+
+	return b.String(), "", nil
+}
+
+// referenceEnd returns the index of the "}" closing a reference embedded in
+// a template, which s begins just after, skipping over any placeholders in
+// it. It returns -1 if the reference is not closed.
+func referenceEnd(s string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '$' && i+1 < len(s) && s[i+1] == '{':
+			depth++
+			i++
+		case s[i] == '}':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return -1
+}
@@ -0,0 +1,135 @@
+package awsenv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandPlaceholders(t *testing.T) {
+	t.Parallel()
+	lookup := options{vars: map[string]string{"STAGE": "prod", "APP": "my-app"}}.
+		placeholderLookup(map[string]string{"STAGE": "dev", "REGION": "us-east-1"})
+
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr string
+	}{
+		{
+			name:  "none",
+			input: "/prod/my-app/db",
+			want:  "/prod/my-app/db",
+		},
+		{
+			name:  "vars_before_env",
+			input: "/${STAGE}/${APP}/db#pass",
+			want:  "/prod/my-app/db#pass",
+		},
+		{
+			name:  "env",
+			input: "/${REGION}/db?default=x",
+			want:  "/us-east-1/db?default=x",
+		},
+		{
+			name:  "escaped",
+			input: "/$${STAGE}/db",
+			want:  "/${STAGE}/db",
+		},
+		{
+			name:    "undefined",
+			input:   "/${STAGE}/${TEAM}/db",
+			wantErr: "undefined placeholder ${TEAM}",
+		},
+		{
+			name:    "invalid_name",
+			input:   "/${STAGE-1}/db",
+			wantErr: `invalid placeholder "${STAGE-1}"`,
+		},
+		{
+			name:    "unterminated",
+			input:   "/${STAGE/db",
+			wantErr: `unterminated placeholder "${STAGE/db"`,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := expandPlaceholders(test.input, lookup)
+			if test.wantErr != "" {
+				require.EqualError(t, err, test.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestExpandTemplatePlaceholders(t *testing.T) {
+	t.Parallel()
+	lookup := options{}.placeholderLookup(map[string]string{"STAGE": "prod"})
+
+	got, failed, err := expandTemplatePlaceholders("${HOME}:${awsenv:/${STAGE}/db}:$${awsenv:/${STAGE}}", DefaultPrefix, lookup)
+	require.NoError(t, err)
+	require.Empty(t, failed)
+	require.Equal(t, "${HOME}:${awsenv:/prod/db}:$${awsenv:/${STAGE}}", got)
+
+	_, failed, err = expandTemplatePlaceholders("a ${awsenv:/${STAGE}/db} b ${awsenv:/${TEAM}/db}", DefaultPrefix, lookup)
+	require.EqualError(t, err, "undefined placeholder ${TEAM}")
+	require.Equal(t, "/${TEAM}/db", failed)
+}
+
+func TestParseTemplate_placeholders(t *testing.T) {
+	t.Parallel()
+	tmpl, err := parseTemplate("x${awsenv:/${STAGE}/db}y", DefaultPrefix)
+	require.NoError(t, err)
+	require.Equal(t, []string{"/${STAGE}/db"}, tmpl.paths())
+}
+
+func TestReplacer_ReplaceAll_Placeholders(t *testing.T) {
+	// Not parallel: this test mutates global environ/setenv via fakeEnv.install()
+	origEnviron := environ
+	origSetenv := setenv
+	t.Cleanup(func() {
+		environ = origEnviron
+		setenv = origSetenv
+	})
+
+	env := fakeEnv{
+		"STAGE":        "prod",
+		"DB_PASS":      "awsenv:/${STAGE}/my-app/db/pass",
+		"DATABASE_URL": "postgres://app:${awsenv:/${STAGE}/my-app/db/pass}@${DB_HOST}/app",
+		"CONFIG":       "awsenv-expand:/${STAGE}/my-app/config",
+		"API_KEY":      "awsenv:/${STAGE}/${TEAM}/api-key",
+		"MISSING":      "awsenv:/${STAGE}/my-app/missing",
+	}
+	env.install()
+
+	params := lenientParamStore{
+		"/prod/my-app/db/pass": "s3cr3t",
+		"/prod/my-app/config":  "LOG_LEVEL=debug",
+		"/dev/my-app/db/pass":  "wrong",
+	}
+
+	r := NewReplacer(DefaultPrefix, params, WithVars(map[string]string{"DB_HOST": "ignored"}))
+	err := r.ReplaceAll(context.Background())
+	require.EqualError(t, err, `awsenv: 2 references could not be resolved: `+
+		`API_KEY: "/${STAGE}/${TEAM}/api-key": undefined placeholder ${TEAM}; `+
+		`MISSING: "/prod/my-app/missing": param not found`)
+
+	want := fakeEnv{
+		"STAGE":        "prod",
+		"DB_PASS":      "s3cr3t",
+		"DATABASE_URL": "postgres://app:s3cr3t@${DB_HOST}/app",
+		"CONFIG":       "LOG_LEVEL=debug",
+		"LOG_LEVEL":    "debug",
+		"API_KEY":      "awsenv:/${STAGE}/${TEAM}/api-key",
+		"MISSING":      "awsenv:/${STAGE}/my-app/missing",
+	}
+	require.Equal(t, want, env)
+}
@@ -0,0 +1,297 @@
+package awsenv
+
+import (
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// reference is a parsed parameter reference: everything in a value after
+// the prefix.
+//
+//	example: `/prod/db#password` or `/prod/db#/credentials/password`
+//
+// Options may follow a "?" in URL query form, e.g. `/prod/db?default=foo`,
+// `/prod/db?optional`, `/prod/config?prefix=APP_` or `/prod/db?region=us-west-2`.
+//
+// Transforms may follow, each after a "|", e.g. `/tls/key|base64decode|trim`.
+type reference struct {
+	// path is the name or path passed to the ParamsGetter.
+	path string
+	// field optionally selects a single field from a JSON document value.
+	// A field beginning with "/" is a JSON pointer (RFC 6901), otherwise
+	// it names a top-level key.
+	field string
+	// namePrefix is prepended to the names of expanded env vars.
+	namePrefix string
+	// optional allows the param or field to be missing, in which case
+	// defaultValue is used.
+	optional     bool
+	defaultValue string
+	// region is the region a parameter path is looked up in, instead of the
+	// ParamsGetter's own. qualify rewrites path to the parameter's ARN.
+	region string
+	// candidates are the paths a relative path is looked up at, one per
+	// search path, in order. The value is taken from the first that exists.
+	candidates []string
+	// transforms are applied in turn to the value of the param or field,
+	// but not to defaultValue.
+	transforms []transformCall
+}
+
+// parseReference splits a raw reference into its components.
+func parseReference(raw string) (reference, error) {
+	var ref reference
+
+	if idx := strings.Index(raw, "|"); idx >= 0 {
+		transforms, err := parseTransforms(raw[idx+1:])
+		if err != nil {
+			return ref, errors.Wrap(err, "invalid reference")
+		}
+		ref.transforms = transforms
+		raw = raw[:idx]
+	}
+
+	if idx := strings.Index(raw, "?"); idx >= 0 {
+		err := ref.parseOptions(raw[idx+1:])
+		if err != nil {
+			return ref, errors.Wrap(err, "invalid reference")
+		}
+		raw = raw[:idx]
+	}
+
+	if idx := strings.Index(raw, "#"); idx >= 0 {
+		raw, ref.field = raw[:idx], raw[idx+1:]
+	}
+	ref.path = raw
+
+	return ref, nil
+}
+
+// reference parses a raw reference, and qualifies it as set in o.
+func (o options) reference(raw string) (reference, error) {
+	ref, err := parseReference(raw)
+	if err != nil {
+		return ref, err
+	}
+	return o.qualify(ref)
+}
+
+// qualify completes the path of a parsed reference: a relative path is
+// joined to the base path or, given several search paths, to each of them
+// in turn, and a path with a region is rewritten to the parameter's ARN.
+func (o options) qualify(ref reference) (reference, error) {
+	if o.isRelative(ref.path) {
+		switch len(o.searchPaths) {
+		case 0:
+		case 1:
+			ref.path = o.searchPaths[0] + "/" + ref.path
+		default:
+			ref.candidates = make([]string, len(o.searchPaths))
+			for i, base := range o.searchPaths {
+				ref.candidates[i] = base + "/" + ref.path
+			}
+		}
+	}
+
+	if ref.region != "" {
+		if len(ref.candidates) == 0 && !strings.HasPrefix(ref.path, "/") {
+			return ref, errors.New("invalid reference: option \"region\" requires a parameter path beginning with /")
+		}
+		for i, path := range ref.candidates {
+			ref.candidates[i] = regionalARN(ref.region, path)
+		}
+		if len(ref.candidates) == 0 {
+			ref.path = regionalARN(ref.region, ref.path)
+		}
+	}
+
+	return ref, nil
+}
+
+// paths returns the paths to fetch for ref.
+func (ref reference) paths() []string {
+	if len(ref.candidates) > 0 {
+		return ref.candidates
+	}
+	return []string{ref.path}
+}
+
+// isRelative reports whether path is relative to the base path: a Parameter
+// Store name not beginning with "/", rather than an absolute path, an ARN or
+// a name beginning with a registered scheme. A relative name may still have
+// a version or label selector, e.g. `db/pass:7`.
+func (o options) isRelative(path string) bool {
+	if path == "" || strings.HasPrefix(path, "/") || strings.HasPrefix(path, "arn:") {
+		return false
+	}
+
+	scheme, _, ok := strings.Cut(path, ":")
+	if !ok || strings.Contains(scheme, "/") || o.schemes == nil {
+		return true
+	}
+	return !o.schemes.HasScheme(scheme)
+}
+
+// parseOptions sets the options given in URL query form.
+func (ref *reference) parseOptions(query string) error {
+	opts, err := url.ParseQuery(query)
+	if err != nil {
+		return err
+	}
+
+	for key, vals := range opts {
+		val := vals[len(vals)-1]
+
+		switch key {
+		case "prefix":
+			ref.namePrefix = val
+		case "region":
+			ref.region = val
+		case "default":
+			ref.optional = true
+			ref.defaultValue = val
+		case "optional":
+			optional := true
+			if val != "" {
+				optional, err = strconv.ParseBool(val)
+				if err != nil {
+					return errors.Errorf("invalid value %q for option %q", val, key)
+				}
+			}
+			ref.optional = ref.optional || optional
+		default:
+			return errors.Errorf("unknown option %q", key)
+		}
+	}
+
+	return nil
+}
+
+// lookup returns the value for ref in vals, the results of fetch, along
+// with the key it was found under. Given candidates, the first found is
+// used.
+func (ref reference) lookup(vals map[string]string) (string, string, bool) {
+	for _, path := range ref.paths() {
+		if val, ok := vals[path]; ok {
+			return val, path, true
+		}
+
+		// values from the env will still include the fully qualified prefix, but the replacement will not
+		key := stripARNPrefix(path)
+		if val, ok := vals[key]; ok {
+			return val, key, true
+		}
+	}
+	return "", "", false
+}
+
+// searched calls the search hook, if any, with the path the value for ref
+// was found under in vals, if ref was looked up in several search paths.
+func (o options) searched(source string, ref reference, vals map[string]string) {
+	if o.searchHook == nil || len(ref.candidates) == 0 {
+		return
+	}
+	if _, key, ok := ref.lookup(vals); ok {
+		o.searchHook(source, key)
+	}
+}
+
+// resolve looks up the value for ref in vals, the results of fetch, and
+// applies its field selector and transforms, looked up in custom before the
+// built-in ones. If the param or field is missing and ref is optional, its
+// default value is returned instead.
+func (ref reference) resolve(vals map[string]string, custom map[string]Transform) (string, error) {
+	val, _, ok := ref.lookup(vals)
+	if !ok {
+		if ref.optional {
+			return ref.defaultValue, nil
+		}
+		if len(ref.candidates) > 0 {
+			return "", errors.Wrapf(ErrParamNotFound, "searched %s", strings.Join(ref.candidates, ", "))
+		}
+		return "", ErrParamNotFound
+	}
+
+	val, ok, err := ref.extract(val)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		if ref.optional {
+			return ref.defaultValue, nil
+		}
+		return "", errors.Errorf("field %q not found", ref.field)
+	}
+
+	return applyTransforms(val, ref.transforms, custom)
+}
+
+// extract applies the reference's field selector, if any, to val. It
+// reports whether the field was found.
+func (ref reference) extract(val string) (string, bool, error) {
+	if ref.field == "" {
+		return val, true, nil
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal([]byte(val), &doc); err != nil {
+		return "", false, errors.New("not a JSON document")
+	}
+
+	field, ok := jsonField(doc, ref.field)
+	if !ok {
+		return "", false, nil
+	}
+
+	if s, ok := field.(string); ok {
+		return s, true, nil
+	}
+
+	// non-string fields are returned in their JSON form
+	b, err := json.Marshal(field)
+	if err != nil {
+		return "", false, errors.Wrapf(err, "field %q", ref.field)
+	}
+
+	return string(b), true, nil
+}
+
+// jsonField selects a field from a decoded JSON document, either by a
+// top-level key or, if field begins with "/", by JSON pointer.
+func jsonField(doc interface{}, field string) (interface{}, bool) {
+	if !strings.HasPrefix(field, "/") {
+		obj, ok := doc.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		val, ok := obj[field]
+		return val, ok
+	}
+
+	for _, token := range strings.Split(field[1:], "/") {
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			val, ok := node[token]
+			if !ok {
+				return nil, false
+			}
+			doc = val
+		case []interface{}:
+			idx, err := strconv.Atoi(token)
+			if err != nil || idx < 0 || idx >= len(node) {
+				return nil, false
+			}
+			doc = node[idx]
+		default:
+			return nil, false
+		}
+	}
+
+	return doc, true
+}
@@ -0,0 +1,372 @@
+package awsenv
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseReference(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name  string
+		input string
+		want  reference
+	}{
+		{
+			name:  "plain_path",
+			input: "/prod/db",
+			want:  reference{path: "/prod/db"},
+		},
+		{
+			name:  "field",
+			input: "/prod/db#password",
+			want:  reference{path: "/prod/db", field: "password"},
+		},
+		{
+			name:  "json_pointer",
+			input: "/prod/db#/credentials/password",
+			want:  reference{path: "/prod/db", field: "/credentials/password"},
+		},
+		{
+			name:  "arn_with_field",
+			input: "arn:aws:ssm:us-east-1:123456789012:parameter/prod/db#user",
+			want:  reference{path: "arn:aws:ssm:us-east-1:123456789012:parameter/prod/db", field: "user"},
+		},
+		{
+			name:  "name_prefix",
+			input: "/prod/config#app?prefix=APP_",
+			want:  reference{path: "/prod/config", field: "app", namePrefix: "APP_"},
+		},
+		{
+			name:  "default",
+			input: "/prod/db#user?default=foo%20bar",
+			want:  reference{path: "/prod/db", field: "user", optional: true, defaultValue: "foo bar"},
+		},
+		{
+			name:  "optional",
+			input: "/prod/db?optional",
+			want:  reference{path: "/prod/db", optional: true},
+		},
+		{
+			name:  "not_optional",
+			input: "/prod/db?optional=false",
+			want:  reference{path: "/prod/db"},
+		},
+		{
+			name:  "region",
+			input: "/prod/db?region=us-west-2",
+			want:  reference{path: "/prod/db", region: "us-west-2"},
+		},
+		{
+			name:  "transforms",
+			input: "/tls/key#pem?default=none|base64decode|json-field:/a/b|trim",
+			want: reference{
+				path:         "/tls/key",
+				field:        "pem",
+				optional:     true,
+				defaultValue: "none",
+				transforms: []transformCall{
+					{name: "base64decode"},
+					{name: "json-field", arg: "/a/b"},
+					{name: "trim"},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := parseReference(test.input)
+			require.NoError(t, err)
+			require.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestParseReference_invalid(t *testing.T) {
+	t.Parallel()
+	_, err := parseReference("/prod/db?unknown=1")
+	require.EqualError(t, err, `invalid reference: unknown option "unknown"`)
+
+	_, err = parseReference("/prod/db?prefix=%zz")
+	require.Error(t, err)
+
+	_, err = parseReference("/prod/db?optional=maybe")
+	require.EqualError(t, err, `invalid reference: invalid value "maybe" for option "optional"`)
+
+	_, err = parseReference("/prod/db|trim||upper")
+	require.EqualError(t, err, "invalid reference: empty transform")
+}
+
+func TestOptions_reference(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		basePath string
+		input    string
+		want     string
+		wantErr  string
+	}{
+		{
+			name:  "no_base_path",
+			input: "db/pass",
+			want:  "db/pass",
+		},
+		{
+			name:     "relative",
+			basePath: "/prod/us-east-1/my-app",
+			input:    "db/pass#user",
+			want:     "/prod/us-east-1/my-app/db/pass",
+		},
+		{
+			name:     "absolute",
+			basePath: "/prod/us-east-1/my-app",
+			input:    "/shared/db/pass",
+			want:     "/shared/db/pass",
+		},
+		{
+			name:     "arn",
+			basePath: "/prod/us-east-1/my-app",
+			input:    "arn:aws:ssm:us-east-1:123456789012:parameter/prod/db",
+			want:     "arn:aws:ssm:us-east-1:123456789012:parameter/prod/db",
+		},
+		{
+			name:     "scheme",
+			basePath: "/prod/us-east-1/my-app",
+			input:    "sm:prod/db",
+			want:     "sm:prod/db",
+		},
+		{
+			name:     "relative_version",
+			basePath: "/prod/us-east-1/my-app",
+			input:    "db/pass:7#user",
+			want:     "/prod/us-east-1/my-app/db/pass:7",
+		},
+		{
+			name:     "relative_label",
+			basePath: "/prod/us-east-1/my-app",
+			input:    "db/pass:prod",
+			want:     "/prod/us-east-1/my-app/db/pass:prod",
+		},
+		{
+			name:     "relative_name_version",
+			basePath: "/prod/us-east-1/my-app",
+			input:    "pass:7",
+			want:     "/prod/us-east-1/my-app/pass:7",
+		},
+		{
+			name:     "absolute_version",
+			basePath: "/prod/us-east-1/my-app",
+			input:    "/shared/db/pass:7",
+			want:     "/shared/db/pass:7",
+		},
+		{
+			name:  "region",
+			input: "/prod/db?region=us-west-2",
+			want:  "arn:aws:ssm:us-west-2::parameter/prod/db",
+		},
+		{
+			name:  "govcloud_region",
+			input: "/prod/db#user?region=us-gov-east-1",
+			want:  "arn:aws-us-gov:ssm:us-gov-east-1::parameter/prod/db",
+		},
+		{
+			name:     "relative_region",
+			basePath: "/prod/my-app",
+			input:    "db?region=eu-west-1",
+			want:     "arn:aws:ssm:eu-west-1::parameter/prod/my-app/db",
+		},
+		{
+			name:     "single_search_path",
+			basePath: "/prod/my-app/",
+			input:    "db",
+			want:     "/prod/my-app/db",
+		},
+		{
+			name:    "scheme_region",
+			input:   "sm:prod/db?region=us-west-2",
+			wantErr: `invalid reference: option "region" requires a parameter path beginning with /`,
+		},
+	}
+
+	router := NewRouter(nil)
+	router.Register("sm", nil)
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			o := newOptions([]Option{WithBasePath(test.basePath)}).withGetter(router)
+			got, err := o.reference(test.input)
+			if test.wantErr != "" {
+				require.EqualError(t, err, test.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.want, got.path)
+		})
+	}
+}
+
+func TestOptions_reference_searchPaths(t *testing.T) {
+	t.Parallel()
+	o := newOptions([]Option{WithSearchPaths("/prod/my-app", "/prod/shared", "/global")})
+
+	ref, err := o.reference("db#pass?region=us-west-2")
+	require.NoError(t, err)
+	require.Equal(t, "db", ref.path)
+	require.Equal(t, []string{
+		"arn:aws:ssm:us-west-2::parameter/prod/my-app/db",
+		"arn:aws:ssm:us-west-2::parameter/prod/shared/db",
+		"arn:aws:ssm:us-west-2::parameter/global/db",
+	}, ref.paths())
+
+	ref, err = o.reference("db:prod#pass")
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"/prod/my-app/db:prod",
+		"/prod/shared/db:prod",
+		"/global/db:prod",
+	}, ref.paths())
+
+	ref, err = o.reference("/prod/db")
+	require.NoError(t, err)
+	require.Equal(t, []string{"/prod/db"}, ref.paths())
+}
+
+func TestReference_resolve_optional(t *testing.T) {
+	t.Parallel()
+	vals := map[string]string{"/prod/db": `{"user":"x"}`}
+
+	tests := []struct {
+		name    string
+		ref     reference
+		want    string
+		wantErr string
+	}{
+		{
+			name:    "missing_param",
+			ref:     reference{path: "/prod/missing"},
+			wantErr: "param not found",
+		},
+		{
+			name: "missing_param_default",
+			ref:  reference{path: "/prod/missing", optional: true, defaultValue: "foo"},
+			want: "foo",
+		},
+		{
+			name: "missing_param_optional",
+			ref:  reference{path: "/prod/missing", optional: true},
+			want: "",
+		},
+		{
+			name: "missing_field_default",
+			ref:  reference{path: "/prod/db", field: "password", optional: true, defaultValue: "foo"},
+			want: "foo",
+		},
+		{
+			name: "present_ignores_default",
+			ref:  reference{path: "/prod/db", field: "user", optional: true, defaultValue: "foo"},
+			want: "x",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := test.ref.resolve(vals, nil)
+			if test.wantErr != "" {
+				require.EqualError(t, err, test.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestReference_resolve(t *testing.T) {
+	t.Parallel()
+	const doc = `{"user":"x","password":"y","port":5432,"nested":{"a/b":{"list":["zero","one"]},"flag":true}}`
+
+	tests := []struct {
+		name    string
+		field   string
+		value   string
+		want    string
+		wantErr string
+	}{
+		{
+			name:  "no_field",
+			value: doc,
+			want:  doc,
+		},
+		{
+			name:  "top_level_key",
+			field: "password",
+			value: doc,
+			want:  "y",
+		},
+		{
+			name:  "number",
+			field: "port",
+			value: doc,
+			want:  "5432",
+		},
+		{
+			name:  "object",
+			field: "/nested/a~1b",
+			value: doc,
+			want:  `{"list":["zero","one"]}`,
+		},
+		{
+			name:  "pointer_into_array",
+			field: "/nested/a~1b/list/1",
+			value: doc,
+			want:  "one",
+		},
+		{
+			name:  "pointer_bool",
+			field: "/nested/flag",
+			value: doc,
+			want:  "true",
+		},
+		{
+			name:    "missing_key",
+			field:   "token",
+			value:   doc,
+			wantErr: `field "token" not found`,
+		},
+		{
+			name:    "missing_pointer",
+			field:   "/nested/a~1b/list/2",
+			value:   doc,
+			wantErr: `field "/nested/a~1b/list/2" not found`,
+		},
+		{
+			name:    "not_json",
+			field:   "password",
+			value:   "plain-text",
+			wantErr: "not a JSON document",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			ref := reference{path: "/prod/db", field: test.field}
+			got, err := ref.resolve(map[string]string{"/prod/db": test.value}, nil)
+			if test.wantErr != "" {
+				require.EqualError(t, err, test.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.want, got)
+		})
+	}
+}
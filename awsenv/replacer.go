@@ -4,6 +4,7 @@ import (
 	"context"
 	"os"
 	"strings"
+	"sync"
 
 	"github.com/pkg/errors"
 	"golang.org/x/sync/errgroup"
@@ -18,7 +19,9 @@ var (
 var DefaultPrefix = "awsenv:"
 
 // ParamsGetter represents a data source that can translate parameter names
-// or paths into parameter values.
+// or paths into parameter values. Names that do not exist are left out of
+// the result. A ParamsGetter may also return an *InvalidParamsError along
+// with the values it did retrieve, to report names it was told are invalid.
 type ParamsGetter interface {
 	GetParams(ctx context.Context, names []string) (map[string]string, error)
 }
@@ -36,35 +39,56 @@ type LimitedParamsGetter interface {
 // given value prefix, using the given ParamsGetter.
 //
 // NewReplacer will panic if envValuePrefix is the empty string.
-func NewReplacer(envValuePrefix string, ssm ParamsGetter) *Replacer {
+func NewReplacer(envValuePrefix string, ssm ParamsGetter, opts ...Option) *Replacer {
 	if envValuePrefix == "" {
 		panic("awsenv: envValuePrefix must be non-empty")
 	}
 
 	return &Replacer{
-		ssm:    ssm,
-		prefix: envValuePrefix,
+		ssm:     ssm,
+		prefix:  envValuePrefix,
+		options: newOptions(opts).withGetter(ssm),
 	}
 }
 
 // Replacer handles replacing existing environment variables with values
 // retrieved from AWS Parameter Store.
+//
+// Env vars whose value does not begin with the prefix may still embed any
+// number of references, delimited as ${<prefix><reference>}, e.g.
+// `postgres://app:${awsenv:/prod/db/pass}@db/app`. The literal text
+// "${<prefix>" is written "$${<prefix>".
+//
+// Env vars whose value begins with the expand prefix (DefaultExpandPrefix
+// unless changed by WithExpandPrefix) refer to a parameter holding many env
+// vars, as a JSON object or in dotenv format. Each of those is added to the
+// environment, unless an env var of the same name is already set.
+//
+// Env vars may also be mapped to parameters with WithMapping, rather than
+// set to references in the environment.
+//
+// Placeholders in reference paths, e.g. `/${STAGE}/my-app/db`, are filled
+// from WithVars and then from the environment, before anything is fetched.
 type Replacer struct {
 	ssm    ParamsGetter
 	prefix string
+	options
 }
 
 // ReplaceAll overwrites applicable environment variables with values
 // retrieved from Parameter Store. ReplaceAll will attempt to replace
-// as many values as possible.
+// as many values as possible, after which it will return an error
+// combining everything that went wrong.
 func (r *Replacer) ReplaceAll(ctx context.Context) error {
-	vars, err := r.Replacements(ctx)
-	if err != nil {
+	vars, err := r.Resolved(ctx)
+
+	var rerr *ResolveError
+	if err != nil && !errors.As(err, &rerr) {
 		return err
 	}
 
-	for name, val := range vars {
-		suberr := setenv(name, val)
+	for _, name := range sortedKeys(vars) {
+		suberr := setenv(name, vars[name])
 		if err == nil && suberr != nil {
 			err = suberr
 		}
@@ -81,26 +105,222 @@ func (r *Replacer) MustReplaceAll(ctx context.Context) {
 	}
 }
 
-// Replacements returns a map of environment variable names to new values
-// that have been fetched from Parameter Store.
+// Replacements returns the entire environment, with the values that have
+// been fetched from Parameter Store merged in. Use Resolved to get only the
+// env vars that changed. If some references cannot be resolved, the values
+// that could be are returned along with a *ResolveError listing every
+// reference that could not.
 func (r *Replacer) Replacements(ctx context.Context) (map[string]string, error) {
+	envvars, _, err := r.replace(ctx)
+	return envvars, err
+}
+
+// Resolved returns only the env vars whose values have been fetched from
+// Parameter Store: those referring to or embedding parameters, and those
+// added by expansion. Errors are returned as by Replacements.
+func (r *Replacer) Resolved(ctx context.Context) (map[string]string, error) {
+	_, resolved, err := r.replace(ctx)
+	return resolved, err
+}
+
+// replace returns the entire environment with the values fetched from
+// Parameter Store merged in, along with the env vars that changed.
+func (r *Replacer) replace(ctx context.Context) (envvars, resolved map[string]string, err error) {
 	// environment variables parsed
-	envvars := parseEnvironment(environ())
+	orig := parseEnvironment(environ())
+	envvars = copyEnv(orig)
+
+	// mapped env vars are resolved as if they held their reference
+	mapped := make(map[string]string, len(r.mapping))
+	for name, ref := range r.mapping {
+		if _, ok := envvars[name]; ok {
+			continue
+		}
+		mapped[name] = r.prefix + ref
+		envvars[name] = mapped[name]
+	}
+
+	var rerr ResolveError
+
+	// placeholders are filled from the environment as it was set
+	written := copyEnv(envvars)
+	failed := r.expandPlaceholders(envvars, r.placeholderLookup(orig), &rerr)
+	filled := copyEnv(envvars)
 
 	// param path
 	pathvars := r.filterPaths(envvars)
+	pathvars = append(pathvars, r.filterExpandPaths(envvars)...)
 
 	// param path -> env value
-	pathvals, err := fetch(ctx, r.ssm, pathvars)
+	pathvals, reasons, err := fetchNested(ctx, r.ssm, pathvars, r.prefix, r.options)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	expanded := r.expandParamPathValues(envvars, pathvals, &rerr)
+	envvars = r.applyParamPathValues(envvars, pathvals, &rerr)
+
+	// values that could not be resolved are left as written
+	for name, value := range filled {
+		if envvars[name] == value {
+			envvars[name] = written[name]
+		}
+	}
+	for name, value := range failed {
+		envvars[name] = value
+	}
+
+	for name, value := range expanded {
+		if _, ok := envvars[name]; ok && !r.expandOverride {
+			continue
+		}
+		envvars[name] = value
+	}
+
+	// mapped env vars that could not be resolved are left unset
+	for name, value := range mapped {
+		if envvars[name] == value {
+			delete(envvars, name)
+		}
+	}
+
+	resolved = make(map[string]string)
+	for name, value := range envvars {
+		if prev, ok := orig[name]; !ok || prev != value {
+			resolved[name] = value
+		}
 	}
 
-	envvars = r.applyParamPathValues(envvars, pathvals)
-	return envvars, nil
+	return envvars, resolved, rerr.err(reasons)
 }
 
-// filterPaths filters out all the path.
+// expandPlaceholders fills the ${NAME} placeholders in the references that
+// env vars hold or embed. Env vars holding placeholders that cannot be
+// filled are removed from envvars and returned, and the errors added to
+// rerr.
+func (r *Replacer) expandPlaceholders(envvars map[string]string, lookup lookupFunc, rerr *ResolveError) map[string]string {
+	failed := make(map[string]string)
+
+	for _, name := range sortedKeys(envvars) {
+		value := envvars[name]
+
+		var (
+			expanded, raw string
+			err           error
+		)
+		switch {
+		case r.isTemplate(value):
+			expanded, raw, err = expandTemplatePlaceholders(value, r.prefix, lookup)
+		case r.isExpansion(value):
+			raw = strings.TrimPrefix(value, r.expandPrefix)
+			expanded, err = expandPlaceholders(raw, lookup)
+			expanded = r.expandPrefix + expanded
+		case r.isReference(value):
+			raw = strings.TrimPrefix(value, r.prefix)
+			expanded, err = expandPlaceholders(raw, lookup)
+			expanded = r.prefix + expanded
+		default:
+			continue
+		}
+
+		if err != nil {
+			rerr.add(name, raw, err)
+			failed[name] = value
+			delete(envvars, name)
+			continue
+		}
+		envvars[name] = expanded
+	}
+
+	return failed
+}
+
+// isExpansion reports whether value refers to a parameter to be expanded.
+func (r *Replacer) isExpansion(value string) bool {
+	return r.expandPrefix != "" && strings.HasPrefix(value, r.expandPrefix)
+}
+
+// isReference reports whether value refers to a parameter.
+func (r *Replacer) isReference(value string) bool {
+	return strings.HasPrefix(value, r.prefix) && !r.isExpansion(value)
+}
+
+// isTemplate reports whether value embeds references, without being one.
+func (r *Replacer) isTemplate(value string) bool {
+	return !strings.HasPrefix(value, r.prefix) && !r.isExpansion(value) && isTemplate(value, r.prefix)
+}
+
+// filterExpandPaths returns the paths of all parameters to be expanded.
+func (r *Replacer) filterExpandPaths(envvars map[string]string) []string {
+	var values []string
+
+	for _, value := range envvars {
+		if !r.isExpansion(value) {
+			continue
+		}
+
+		ref, err := r.reference(strings.TrimPrefix(value, r.expandPrefix))
+		if err != nil {
+			// reported by expandParamPathValues
+			continue
+		}
+		values = append(values, ref.paths()...)
+	}
+
+	return values
+}
+
+// expandParamPathValues replaces each env var referring to a parameter to be
+// expanded with the parameter's value, and returns the env vars it holds.
+// References that cannot be resolved are added to rerr.
+func (r *Replacer) expandParamPathValues(srcEnv map[string]string, replaceWithValues map[string]string, rerr *ResolveError) map[string]string {
+	expanded := make(map[string]string)
+	sources := make(map[string]string)
+
+	for _, name := range sortedKeys(srcEnv) {
+		value := srcEnv[name]
+		if !r.isExpansion(value) {
+			continue
+		}
+
+		raw := strings.TrimPrefix(value, r.expandPrefix)
+		ref, err := r.reference(raw)
+		if err != nil {
+			rerr.add(name, raw, err)
+			continue
+		}
+
+		val, err := ref.resolve(replaceWithValues, r.transforms)
+		if err != nil {
+			rerr.add(name, ref.path, err)
+			continue
+		}
+		r.searched(name, ref, replaceWithValues)
+
+		vars, err := parseExpansion(val)
+		if err != nil {
+			rerr.add(name, ref.path, errors.Wrap(err, "cannot expand"))
+			continue
+		}
+
+		for _, subName := range sortedKeys(vars) {
+			subValue := vars[subName]
+			subName = ref.namePrefix + subName
+			if source, ok := sources[subName]; ok && expanded[subName] != subValue {
+				rerr.add(name, ref.path, errors.Errorf("%s is also expanded from %s with a different value", subName, source))
+				continue
+			}
+			expanded[subName] = subValue
+			sources[subName] = name
+		}
+
+		srcEnv[name] = val
+	}
+
+	return expanded
+}
+
+// filterPaths filters out all the path, including those embedded in values.
 func (r *Replacer) filterPaths(envvars map[string]string) []string {
 	if len(envvars) == 0 {
 		return nil
@@ -109,37 +329,95 @@ func (r *Replacer) filterPaths(envvars map[string]string) []string {
 	values := make([]string, 0, len(envvars))
 
 	for _, value := range envvars {
-		if !strings.HasPrefix(value, r.prefix) {
+		if r.isTemplate(value) {
+			tmpl, err := r.template(value, r.prefix)
+			if err != nil {
+				// reported by applyParamPathValues
+				continue
+			}
+			values = append(values, tmpl.paths()...)
+			continue
+		}
+
+		if !r.isReference(value) {
 			continue
 		}
 
-		values = append(values, strings.TrimPrefix(value, r.prefix))
+		ref, err := r.reference(strings.TrimPrefix(value, r.prefix))
+		if err != nil {
+			// reported by applyParamPathValues
+			continue
+		}
+		values = append(values, ref.paths()...)
 	}
 
 	return values
 }
 
 // applyParamPathValues takes applies values from src keys translated through
-func (r *Replacer) applyParamPathValues(srcEnv map[string]string, replaceWithValues map[string]string) map[string]string {
-	for name, value := range srcEnv {
+// the references they hold or embed. References that cannot be resolved are left
+// unchanged and added to rerr.
+func (r *Replacer) applyParamPathValues(srcEnv map[string]string, replaceWithValues map[string]string, rerr *ResolveError) map[string]string {
+	for _, name := range sortedKeys(srcEnv) {
+		value := srcEnv[name]
+		if r.isTemplate(value) {
+			srcEnv[name] = r.applyTemplate(name, value, replaceWithValues, rerr)
+			continue
+		}
+
 		// If the value lacks a prefix we skip it.
-		if !strings.HasPrefix(value, r.prefix) {
+		if !r.isReference(value) {
+			continue
+		}
+
+		raw := strings.TrimPrefix(value, r.prefix)
+		ref, err := r.reference(raw)
+		if err != nil {
+			rerr.add(name, raw, err)
 			continue
 		}
 
-		lookupValue := strings.TrimPrefix(value, r.prefix)
-		// values from the env will still include the fully qualified prefix, but the replacement will not
-		lookupValue = stripARNPrefix(lookupValue)
-		if val, ok := replaceWithValues[lookupValue]; ok {
-			srcEnv[name] = val
+		val, err := ref.resolve(replaceWithValues, r.transforms)
+		if err != nil {
+			rerr.add(name, ref.path, err)
+			continue
 		}
+		r.searched(name, ref, replaceWithValues)
+		srcEnv[name] = val
 	}
 	return srcEnv
 }
 
+// applyTemplate returns value with the references embedded in it replaced.
+// If any cannot be resolved, they are added to rerr and value is returned
+// unchanged.
+func (r *Replacer) applyTemplate(name, value string, replaceWithValues map[string]string, rerr *ResolveError) string {
+	tmpl, err := r.template(value, r.prefix)
+	if err != nil {
+		rerr.add(name, value, err)
+		return value
+	}
+
+	val, ok := tmpl.render(replaceWithValues, r.options, name, rerr)
+	if !ok {
+		return value
+	}
+	return val
+}
+
+// fetch retrieves the values of paths from ssm, splitting them into
+// concurrent requests no larger than the limit of a LimitedParamsGetter.
+// Paths that could not be found are missing from the result. Names
+// reported invalid by the ParamsGetter are returned in an
+// *InvalidParamsError along with the values that were retrieved.
 func fetch(ctx context.Context, ssm ParamsGetter, paths []string) (map[string]string, error) {
 	eg, ctx := errgroup.WithContext(ctx)
 
+	var (
+		mu      sync.Mutex
+		invalid []string
+	)
+
 	var limit int
 
 	lpg, ok := ssm.(LimitedParamsGetter)
@@ -159,6 +437,14 @@ func fetch(ctx context.Context, ssm ParamsGetter, paths []string) (map[string]st
 		eg.Go(func() error {
 			var err error
 			results[i], err = ssm.GetParams(ctx, batch)
+
+			var inv *InvalidParamsError
+			if errors.As(err, &inv) {
+				mu.Lock()
+				invalid = append(invalid, inv.Names...)
+				mu.Unlock()
+				return nil
+			}
 			return err
 		})
 	}
@@ -172,14 +458,8 @@ func fetch(ctx context.Context, ssm ParamsGetter, paths []string) (map[string]st
 	dest := make(map[string]string, len(paths))
 	merge(dest, results)
 
-	for _, path := range paths {
-		// results from GetParams include only the path (not fully qualified), but the original paths include the fully
-		// qualified name.
-		path = stripARNPrefix(path)
-		_, ok := dest[path]
-		if !ok {
-			return dest, errors.Errorf("awsenv: param not found: %q", path)
-		}
+	if len(invalid) > 0 {
+		return dest, &InvalidParamsError{Names: invalid}
 	}
 
 	return dest, nil
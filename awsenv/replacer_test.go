@@ -357,7 +357,9 @@ func TestReplacer_applyParamPathValues(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			t.Parallel()
 			r := &Replacer{prefix: test.prefix}
-			got, want := r.applyParamPathValues(test.src, test.replaceWithValues), test.want
+			var rerr ResolveError
+			got, want := r.applyParamPathValues(test.src, test.replaceWithValues, &rerr), test.want
+			require.Empty(t, rerr.Errors)
 			require.Equal(t, want, got, "applyParamPathValues(%v, %v) = %v, want %v", test.src, test.replaceWithValues, got, want)
 		})
 	}
@@ -410,3 +412,402 @@ func TestReplacer_ReplaceAll_FullyQualifiedARN(t *testing.T) {
 	}
 	require.Equal(t, want, env)
 }
+
+func TestReplacer_ReplaceAll_JSONField(t *testing.T) {
+	// Not parallel: this test mutates global environ/setenv via fakeEnv.install()
+	origEnviron := environ
+	origSetenv := setenv
+	t.Cleanup(func() {
+		environ = origEnviron
+		setenv = origSetenv
+	})
+
+	env := fakeEnv{
+		"DB_USER":     "awsenv:/prod/db#user",
+		"DB_PASSWORD": "awsenv:/prod/db#/password",
+		"DB_CONFIG":   "awsenv:/prod/db",
+	}
+	env.install()
+
+	params := mockParamStore{
+		"/prod/db": `{"user":"x","password":"y"}`,
+	}
+
+	r := NewReplacer(DefaultPrefix, params)
+	err := r.ReplaceAll(context.Background())
+	require.NoError(t, err)
+
+	want := fakeEnv{
+		"DB_USER":     "x",
+		"DB_PASSWORD": "y",
+		"DB_CONFIG":   `{"user":"x","password":"y"}`,
+	}
+	require.Equal(t, want, env)
+}
+
+func TestReplacer_ReplaceAll_JSONFieldMissing(t *testing.T) {
+	// Not parallel: this test mutates global environ/setenv via fakeEnv.install()
+	origEnviron := environ
+	origSetenv := setenv
+	t.Cleanup(func() {
+		environ = origEnviron
+		setenv = origSetenv
+	})
+
+	env := fakeEnv{
+		"DB_TOKEN": "awsenv:/prod/db#token",
+	}
+	env.install()
+
+	params := mockParamStore{
+		"/prod/db": `{"user":"x","password":"y"}`,
+	}
+
+	r := NewReplacer(DefaultPrefix, params)
+	err := r.ReplaceAll(context.Background())
+	require.EqualError(t, err, `awsenv: DB_TOKEN: "/prod/db": field "token" not found`)
+}
+
+func TestReplacer_ReplaceAll_Selectors(t *testing.T) {
+	// Not parallel: this test mutates global environ/setenv via fakeEnv.install()
+	origEnviron := environ
+	origSetenv := setenv
+	t.Cleanup(func() {
+		environ = origEnviron
+		setenv = origSetenv
+	})
+
+	env := fakeEnv{
+		"LATEST":        "awsenv:/param/path",
+		"VERSION":       "awsenv:/param/path:7",
+		"LABEL":         "awsenv:/param/path:prod-label",
+		"CROSS_ACCOUNT": "awsenv:arn:aws:ssm:us-east-1:123456789012:parameter/remote/secret:2",
+		"FIELD":         "awsenv:/param/json:3#user",
+	}
+	env.install()
+
+	getter := mockParamsGetter(func(_ context.Context, paths []string) (map[string]string, error) {
+		// results are keyed by the plain parameter path including the selector
+		store := map[string]string{
+			"/param/path":            "latest",
+			"/param/path:7":          "v7",
+			"/param/path:prod-label": "prod",
+			"/remote/secret:2":       "remote_v2",
+			"/param/json:3":          `{"user":"v3"}`,
+		}
+		result := make(map[string]string, len(paths))
+		for _, p := range paths {
+			plain := stripARNPrefix(p)
+			val, ok := store[plain]
+			if !ok {
+				return nil, fmt.Errorf("not found: %s", p)
+			}
+			result[plain] = val
+		}
+		return result, nil
+	})
+
+	r := NewReplacer(DefaultPrefix, getter)
+	err := r.ReplaceAll(context.Background())
+	require.NoError(t, err)
+
+	want := fakeEnv{
+		"LATEST":        "latest",
+		"VERSION":       "v7",
+		"LABEL":         "prod",
+		"CROSS_ACCOUNT": "remote_v2",
+		"FIELD":         "v3",
+	}
+	require.Equal(t, want, env)
+}
+
+// lenientParamStore omits missing params from its results, like the SSM API.
+type lenientParamStore map[string]string
+
+func (m lenientParamStore) GetParams(_ context.Context, paths []string) (map[string]string, error) {
+	result := make(map[string]string, len(paths))
+	for _, path := range paths {
+		if val, ok := m[path]; ok {
+			result[path] = val
+		}
+	}
+	return result, nil
+}
+
+func TestReplacer_ReplaceAll_Defaults(t *testing.T) {
+	// Not parallel: this test mutates global environ/setenv via fakeEnv.install()
+	origEnviron := environ
+	origSetenv := setenv
+	t.Cleanup(func() {
+		environ = origEnviron
+		setenv = origSetenv
+	})
+
+	env := fakeEnv{
+		"PRESENT":        "awsenv:/present?default=unused",
+		"DEFAULTED":      "awsenv:/missing?default=foo",
+		"OPTIONAL":       "awsenv:/missing?optional",
+		"DEFAULT_FIELD":  "awsenv:/present#user?default=nobody",
+		"OPTIONAL_SPACE": "awsenv:/missing?default=a+b%26c",
+	}
+	env.install()
+
+	params := lenientParamStore{
+		"/present": `{"password":"y"}`,
+	}
+
+	r := NewReplacer(DefaultPrefix, params)
+	err := r.ReplaceAll(context.Background())
+	require.NoError(t, err)
+
+	want := fakeEnv{
+		"PRESENT":        `{"password":"y"}`,
+		"DEFAULTED":      "foo",
+		"OPTIONAL":       "",
+		"DEFAULT_FIELD":  "nobody",
+		"OPTIONAL_SPACE": "a b&c",
+	}
+	require.Equal(t, want, env)
+}
+
+func TestReplacer_ReplaceAll_RequiredMissing(t *testing.T) {
+	// Not parallel: this test mutates global environ/setenv via fakeEnv.install()
+	origEnviron := environ
+	origSetenv := setenv
+	t.Cleanup(func() {
+		environ = origEnviron
+		setenv = origSetenv
+	})
+
+	env := fakeEnv{
+		"OPTIONAL": "awsenv:/missing?optional",
+		"REQUIRED": "awsenv:/missing",
+	}
+	env.install()
+
+	r := NewReplacer(DefaultPrefix, lenientParamStore{})
+	err := r.ReplaceAll(context.Background())
+	require.EqualError(t, err, `awsenv: REQUIRED: "/missing": param not found`)
+}
+
+func TestReplacer_ReplaceAll_ReportsEveryError(t *testing.T) {
+	// Not parallel: this test mutates global environ/setenv via fakeEnv.install()
+	origEnviron := environ
+	origSetenv := setenv
+	t.Cleanup(func() {
+		environ = origEnviron
+		setenv = origSetenv
+	})
+
+	env := fakeEnv{
+		"A_MISSING": "awsenv:/missing",
+		"B_INVALID": "awsenv:/invalid name",
+		"C_FIELD":   "awsenv:/present#token",
+		"D_SYNTAX":  "awsenv:/present?unknown",
+		"E_OK":      "awsenv:/present",
+	}
+	env.install()
+
+	getter := mockParamsGetter(func(_ context.Context, paths []string) (map[string]string, error) {
+		result := map[string]string{}
+		var invalid []string
+		for _, p := range paths {
+			switch p {
+			case "/present":
+				result[p] = `{"user":"x"}`
+			case "/invalid name":
+				invalid = append(invalid, p)
+			}
+		}
+		return result, &InvalidParamsError{Names: invalid}
+	})
+
+	r := NewReplacer(DefaultPrefix, getter)
+	err := r.ReplaceAll(context.Background())
+
+	var rerr *ResolveError
+	require.True(t, errors.As(err, &rerr), "expected a *ResolveError, got %v", err)
+	require.Len(t, rerr.Errors, 4)
+
+	require.Equal(t, "A_MISSING", rerr.Errors[0].Source)
+	require.Equal(t, "/missing", rerr.Errors[0].Name)
+	require.True(t, errors.Is(rerr.Errors[0], ErrParamNotFound))
+
+	require.Equal(t, "B_INVALID", rerr.Errors[1].Source)
+	require.Equal(t, "/invalid name", rerr.Errors[1].Name)
+	require.True(t, errors.Is(rerr.Errors[1], ErrParamInvalid))
+
+	require.Equal(t, "C_FIELD", rerr.Errors[2].Source)
+	require.Equal(t, "D_SYNTAX", rerr.Errors[3].Source)
+	require.True(t, errors.Is(err, ErrParamNotFound))
+
+	require.EqualError(t, err, `awsenv: 4 references could not be resolved: `+
+		`A_MISSING: "/missing": param not found; `+
+		`B_INVALID: "/invalid name": param invalid or not found; `+
+		`C_FIELD: "/present": field "token" not found; `+
+		`D_SYNTAX: "/present?unknown": invalid reference: unknown option "unknown"`)
+
+	// everything that resolved is still applied
+	require.Equal(t, `{"user":"x"}`, env["E_OK"])
+	require.Equal(t, "awsenv:/missing", env["A_MISSING"])
+}
+
+func TestReplacer_Resolved(t *testing.T) {
+	// Not parallel: this test mutates global environ/setenv via fakeEnv.install()
+	origEnviron := environ
+	origSetenv := setenv
+	t.Cleanup(func() {
+		environ = origEnviron
+		setenv = origSetenv
+	})
+
+	env := fakeEnv{
+		"PATH":         "/usr/bin:/bin",
+		"HOME":         "/home/app",
+		"DB_PASS":      "awsenv:/prod/db/pass",
+		"DATABASE_URL": "postgres://app:${awsenv:/prod/db/pass}@db/app",
+		"APP_CONFIG":   "awsenv-expand:/prod/app/config",
+		"MISSING":      "awsenv:/prod/missing",
+	}
+	env.install()
+
+	params := lenientParamStore{
+		"/prod/db/pass":    "s3cr3t",
+		"/prod/app/config": "LOG_LEVEL=debug\nHOME=/ignored",
+	}
+
+	r := NewReplacer(DefaultPrefix, params, WithExpandPrefix(DefaultExpandPrefix))
+
+	got, err := r.Resolved(context.Background())
+	require.EqualError(t, err, `awsenv: MISSING: "/prod/missing": param not found`)
+	want := map[string]string{
+		"DB_PASS":      "s3cr3t",
+		"DATABASE_URL": "postgres://app:s3cr3t@db/app",
+		"APP_CONFIG":   "LOG_LEVEL=debug\nHOME=/ignored",
+		"LOG_LEVEL":    "debug",
+	}
+	require.Equal(t, want, got)
+
+	all, err := r.Replacements(context.Background())
+	require.Error(t, err)
+	require.Len(t, all, len(env)+1)
+	require.Equal(t, "/usr/bin:/bin", all["PATH"])
+	require.Equal(t, "/home/app", all["HOME"])
+	require.Equal(t, "s3cr3t", all["DB_PASS"])
+	require.Equal(t, "awsenv:/prod/missing", all["MISSING"])
+}
+
+func TestReplacer_ReplaceAll_BasePath(t *testing.T) {
+	// Not parallel: this test mutates global environ/setenv via fakeEnv.install()
+	origEnviron := environ
+	origSetenv := setenv
+	t.Cleanup(func() {
+		environ = origEnviron
+		setenv = origSetenv
+	})
+
+	env := fakeEnv{
+		"DB_PASS":      "awsenv:db/pass",
+		"DATABASE_URL": "postgres://app:${awsenv:db/pass}@db/app",
+		"SHARED":       "awsenv:/shared/key",
+		"POINTER":      "awsenv:pointer",
+		"VERSION":      "awsenv:db/pass:7",
+		"LABEL":        "awsenv:db/pass:prod?default=fallback",
+		"MISSING":      "awsenv:db/missing",
+	}
+	env.install()
+
+	params := lenientParamStore{
+		"/prod/us-east-1/my-app/db/pass":      "s3cr3t",
+		"/prod/us-east-1/my-app/db/pass:7":    "v7",
+		"/prod/us-east-1/my-app/db/pass:prod": "labelled",
+		"/prod/us-east-1/my-app/pointer":      "awsenv:db/pass",
+		"/shared/key":                         "shared",
+		"db/pass":                             "wrong",
+		"db/pass:7":                           "wrong",
+	}
+
+	r := NewReplacer(DefaultPrefix, params, WithBasePath("/prod/us-east-1/my-app/"))
+	err := r.ReplaceAll(context.Background())
+	require.EqualError(t, err, `awsenv: MISSING: "/prod/us-east-1/my-app/db/missing": param not found`)
+
+	want := fakeEnv{
+		"DB_PASS":      "s3cr3t",
+		"DATABASE_URL": "postgres://app:s3cr3t@db/app",
+		"SHARED":       "shared",
+		"POINTER":      "s3cr3t",
+		"VERSION":      "v7",
+		"LABEL":        "labelled",
+		"MISSING":      "awsenv:db/missing",
+	}
+	require.Equal(t, want, env)
+}
+
+func TestReplacer_ReplaceAll_SearchPaths(t *testing.T) {
+	// Not parallel: this test mutates global environ/setenv via fakeEnv.install()
+	origEnviron := environ
+	origSetenv := setenv
+	t.Cleanup(func() {
+		environ = origEnviron
+		setenv = origSetenv
+	})
+
+	env := fakeEnv{
+		"DB_PASS":   "awsenv:db/pass",
+		"LOG_LEVEL": "awsenv:log-level",
+		"REGION":    "awsenv:region",
+		"URL":       "https://${awsenv:api/host}/v1",
+		"ABSOLUTE":  "awsenv:/prod/my-app/db/pass",
+		"PINNED":    "awsenv:db/pass:3",
+		"MISSING":   "awsenv:missing",
+	}
+	env.install()
+
+	params := &recordingParamStore{
+		lenientParamStore: lenientParamStore{
+			"/prod/my-app/db/pass":     "service",
+			"/prod/shared/db/pass":     "team",
+			"/global/db/pass":          "org",
+			"/prod/shared/db/pass:3":   "team-v3",
+			"/global/db/pass:3":        "org-v3",
+			"/prod/shared/log-level":   "info",
+			"/global/log-level":        "warn",
+			"/global/region":           "us-east-1",
+			"/prod/shared/api/host":    "api.internal",
+			"/prod/my-app/unreachable": "x",
+		},
+	}
+
+	searched := make(map[string]string)
+	r := NewReplacer(DefaultPrefix, params,
+		WithSearchPaths("/prod/my-app", "/prod/shared/", "/global"),
+		WithSearchHook(func(source, path string) { searched[source] = path }),
+	)
+	err := r.ReplaceAll(context.Background())
+	require.EqualError(t, err, `awsenv: MISSING: "missing": searched /prod/my-app/missing, /prod/shared/missing, /global/missing: param not found`)
+	require.True(t, errors.Is(err, ErrParamNotFound))
+
+	want := fakeEnv{
+		"DB_PASS":   "service",
+		"LOG_LEVEL": "info",
+		"REGION":    "us-east-1",
+		"URL":       "https://api.internal/v1",
+		"ABSOLUTE":  "service",
+		"PINNED":    "team-v3",
+		"MISSING":   "awsenv:missing",
+	}
+	require.Equal(t, want, env)
+
+	wantSearched := map[string]string{
+		"DB_PASS":   "/prod/my-app/db/pass",
+		"LOG_LEVEL": "/prod/shared/log-level",
+		"REGION":    "/global/region",
+		"URL":       "/prod/shared/api/host",
+		"PINNED":    "/prod/shared/db/pass:3",
+	}
+	require.Equal(t, wantSearched, searched)
+
+	// every candidate is fetched at once
+	require.Len(t, params.requests, 1)
+	require.Len(t, params.requests[0], 19)
+}
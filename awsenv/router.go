@@ -0,0 +1,131 @@
+package awsenv
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+// Router is a ParamsGetter that sends each name to the backend registered
+// for the scheme it starts with, e.g. "ssm" in "ssm:/a/b" or "sm" in
+// "sm:prod/db". Names without a registered scheme are sent to the default
+// backend. Names are passed to backends with the scheme removed, and
+// results are returned keyed by the original, scheme-qualified name.
+type Router struct {
+	fallback ParamsGetter
+	backends map[string]ParamsGetter
+}
+
+// NewRouter returns a Router that sends names without a registered scheme
+// to fallback. If fallback is nil, such names will result in an error.
+func NewRouter(fallback ParamsGetter) *Router {
+	return &Router{
+		fallback: fallback,
+		backends: make(map[string]ParamsGetter),
+	}
+}
+
+// Register sends names beginning with "<scheme>:" to getter.
+//
+// Register will panic if scheme is the empty string or contains a colon.
+func (r *Router) Register(scheme string, getter ParamsGetter) {
+	if scheme == "" || strings.Contains(scheme, ":") {
+		panic("awsenv: scheme must be non-empty and must not contain a colon")
+	}
+
+	r.backends[scheme] = getter
+}
+
+// HasScheme reports whether a backend is registered for scheme.
+func (r *Router) HasScheme(scheme string) bool {
+	_, ok := r.backends[scheme]
+	return ok
+}
+
+// GetParams groups names by scheme and fetches each group from its backend,
+// respecting any limit the backend has on the number of names per request.
+// Invalid names reported by any backend are returned together in an
+// *InvalidParamsError.
+func (r *Router) GetParams(ctx context.Context, names []string) (map[string]string, error) {
+	groups := make(map[string][]string)
+	for _, name := range names {
+		scheme, rest := r.split(name)
+		if scheme == "" && r.fallback == nil {
+			return nil, errors.Errorf("awsenv: no backend for param: %q", name)
+		}
+		groups[scheme] = append(groups[scheme], rest)
+	}
+
+	eg, ctx := errgroup.WithContext(ctx)
+
+	var (
+		mu      sync.Mutex
+		invalid []string
+	)
+	dest := make(map[string]string, len(names))
+
+	for scheme, group := range groups {
+		// copied to avoid race condition
+		scheme, group := scheme, group
+
+		getter := r.fallback
+		if scheme != "" {
+			getter = r.backends[scheme]
+		}
+
+		eg.Go(func() error {
+			vals, err := fetch(ctx, getter, group)
+			groupInvalid, err := invalidNames(err)
+			if err != nil {
+				return err
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			for name, val := range vals {
+				dest[r.join(scheme, name)] = val
+			}
+			for name := range groupInvalid {
+				invalid = append(invalid, r.join(scheme, name))
+			}
+			return nil
+		})
+	}
+
+	err := eg.Wait()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(invalid) > 0 {
+		return dest, &InvalidParamsError{Names: invalid}
+	}
+
+	return dest, nil
+}
+
+// join adds scheme, if any, back to a name returned by its backend.
+func (r *Router) join(scheme, name string) string {
+	if scheme == "" {
+		return name
+	}
+	return scheme + ":" + name
+}
+
+// split separates a registered scheme from the rest of name. If name does not
+// begin with a registered scheme, the scheme is empty and name is returned as is.
+func (r *Router) split(name string) (scheme, rest string) {
+	idx := strings.Index(name, ":")
+	if idx < 0 {
+		return "", name
+	}
+
+	if _, ok := r.backends[name[:idx]]; !ok {
+		return "", name
+	}
+
+	return name[:idx], name[idx+1:]
+}
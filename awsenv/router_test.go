@@ -0,0 +1,136 @@
+package awsenv
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type limitedMockParamStore struct {
+	mockParamStore
+	limit int
+
+	mu      sync.Mutex
+	batches [][]string
+}
+
+func (m *limitedMockParamStore) GetParamsLimit() int { return m.limit }
+
+func (m *limitedMockParamStore) GetParams(ctx context.Context, paths []string) (map[string]string, error) {
+	m.mu.Lock()
+	m.batches = append(m.batches, paths)
+	m.mu.Unlock()
+	return m.mockParamStore.GetParams(ctx, paths)
+}
+
+func TestRouter_panic(t *testing.T) {
+	t.Parallel()
+	r := NewRouter(nil)
+	require.Panics(t, func() { r.Register("", mockParamStore{}) })
+	require.Panics(t, func() { r.Register("a:b", mockParamStore{}) })
+}
+
+func TestRouter_GetParams(t *testing.T) {
+	t.Parallel()
+	ssm := &limitedMockParamStore{
+		mockParamStore: mockParamStore{"/a": "A", "/b": "B", "/c": "C"},
+		limit:          2,
+	}
+	sm := mockParamStore{"prod/db": "DB"}
+
+	r := NewRouter(ssm)
+	r.Register("ssm", ssm)
+	r.Register("sm", sm)
+
+	got, err := r.GetParams(context.Background(), []string{"/a", "ssm:/b", "ssm:/c", "sm:prod/db"})
+	require.NoError(t, err)
+
+	want := map[string]string{
+		"/a":         "A",
+		"ssm:/b":     "B",
+		"ssm:/c":     "C",
+		"sm:prod/db": "DB",
+	}
+	require.Equal(t, want, got)
+
+	// the two ssm: names are batched together, separately from the fallback
+	var sizes []int
+	for _, batch := range ssm.batches {
+		sizes = append(sizes, len(batch))
+	}
+	sort.Ints(sizes)
+	require.Equal(t, []int{1, 2}, sizes)
+}
+
+func TestRouter_GetParams_unregisteredScheme(t *testing.T) {
+	t.Parallel()
+	const arn = "arn:aws:ssm:us-east-1:123456789012:parameter/remote/secret"
+
+	r := NewRouter(mockParamStore{arn: "remote"})
+	r.Register("ssm", mockParamStore{})
+
+	got, err := r.GetParams(context.Background(), []string{arn})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{arn: "remote"}, got)
+}
+
+func TestRouter_GetParams_noFallback(t *testing.T) {
+	t.Parallel()
+	r := NewRouter(nil)
+	r.Register("sm", mockParamStore{"prod/db": "DB"})
+
+	_, err := r.GetParams(context.Background(), []string{"sm:prod/db", "/a"})
+	require.EqualError(t, err, `awsenv: no backend for param: "/a"`)
+}
+
+func TestReplacer_ReplaceAll_Router(t *testing.T) {
+	// Not parallel: this test mutates global environ/setenv via fakeEnv.install()
+	origEnviron := environ
+	origSetenv := setenv
+	t.Cleanup(func() {
+		environ = origEnviron
+		setenv = origSetenv
+	})
+
+	env := fakeEnv{
+		"PLAIN":  "awsenv:/a",
+		"SSM":    "awsenv:ssm:/a",
+		"SECRET": "awsenv:sm:prod/db",
+	}
+	env.install()
+
+	router := NewRouter(mockParamStore{"/a": "fallback"})
+	router.Register("ssm", mockParamStore{"/a": "ssm"})
+	router.Register("sm", mockParamStore{"prod/db": "sm"})
+
+	err := NewReplacer(DefaultPrefix, router).ReplaceAll(context.Background())
+	require.NoError(t, err)
+
+	want := fakeEnv{
+		"PLAIN":  "fallback",
+		"SSM":    "ssm",
+		"SECRET": "sm",
+	}
+	require.Equal(t, want, env)
+}
+
+func TestRouter_GetParams_invalid(t *testing.T) {
+	t.Parallel()
+	invalid := mockParamsGetter(func(_ context.Context, paths []string) (map[string]string, error) {
+		return map[string]string{}, &InvalidParamsError{Names: paths}
+	})
+
+	r := NewRouter(invalid)
+	r.Register("sm", invalid)
+
+	got, err := r.GetParams(context.Background(), []string{"/a", "sm:prod/db"})
+	require.Empty(t, got)
+
+	var inv *InvalidParamsError
+	require.True(t, errors.As(err, &inv), "expected an *InvalidParamsError, got %v", err)
+	require.ElementsMatch(t, []string{"/a", "sm:prod/db"}, inv.Names)
+}
@@ -0,0 +1,67 @@
+package awsenv
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// sharedMemoryDir is a tmpfs on most Linux systems, so files written there
+// never reach a disk.
+const sharedMemoryDir = "/dev/shm"
+
+// SecretFiles holds values written to files by WriteSecretFiles.
+type SecretFiles struct {
+	// Dir is the directory holding the files.
+	Dir string
+	// Paths maps each env var name to the file holding its value.
+	Paths map[string]string
+}
+
+// WriteSecretFiles writes each value in vars to a file named after its env
+// var, readable only by the current user, in a new directory created under
+// dir. If dir is empty, /dev/shm is used when it exists, and the default
+// directory for temporary files otherwise. The files should be removed with
+// Remove once they are no longer needed.
+func WriteSecretFiles(dir string, vars map[string]string) (*SecretFiles, error) {
+	if dir == "" {
+		dir = os.TempDir()
+		if info, err := os.Stat(sharedMemoryDir); err == nil && info.IsDir() {
+			dir = sharedMemoryDir
+		}
+	}
+
+	tmp, err := ioutil.TempDir(dir, "aws-env-")
+	if err != nil {
+		return nil, errors.Wrap(err, "awsenv: cannot create secret files directory")
+	}
+
+	files := &SecretFiles{
+		Dir:   tmp,
+		Paths: make(map[string]string, len(vars)),
+	}
+
+	for _, name := range sortedKeys(vars) {
+		if !envNamePattern.MatchString(name) {
+			_ = files.Remove()
+			return nil, errors.Errorf("awsenv: invalid env var name %q", name)
+		}
+
+		path := filepath.Join(tmp, name)
+		err := ioutil.WriteFile(path, []byte(vars[name]), 0600)
+		if err != nil {
+			_ = files.Remove()
+			return nil, errors.Wrapf(err, "awsenv: cannot write secret file for %s", name)
+		}
+		files.Paths[name] = path
+	}
+
+	return files, nil
+}
+
+// Remove deletes the files and the directory holding them.
+func (f *SecretFiles) Remove() error {
+	return os.RemoveAll(f.Dir)
+}
@@ -0,0 +1,61 @@
+package awsenv
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteSecretFiles(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+
+	files, err := WriteSecretFiles(dir, map[string]string{
+		"DB_PASSWORD": "s3cr3t",
+		"TLS_KEY":     "-----BEGIN-----\n",
+	})
+	require.NoError(t, err)
+	require.Equal(t, dir, filepath.Dir(files.Dir))
+
+	info, err := os.Stat(files.Dir)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0700), info.Mode().Perm())
+
+	want := map[string]string{
+		"DB_PASSWORD": "s3cr3t",
+		"TLS_KEY":     "-----BEGIN-----\n",
+	}
+	require.Len(t, files.Paths, len(want))
+	for name, val := range want {
+		path := files.Paths[name]
+		require.Equal(t, filepath.Join(files.Dir, name), path)
+
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+		got, err := ioutil.ReadFile(path) //nolint: gosec
+		require.NoError(t, err)
+		require.Equal(t, val, string(got))
+	}
+
+	require.NoError(t, files.Remove())
+	_, err = os.Stat(files.Dir)
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestWriteSecretFiles_invalidName(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+
+	_, err := WriteSecretFiles(dir, map[string]string{"../escape": "x"})
+	require.EqualError(t, err, `awsenv: invalid env var name "../escape"`)
+
+	// nothing is left behind
+	entries, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
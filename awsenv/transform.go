@@ -0,0 +1,107 @@
+package awsenv
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Transform converts a parameter value. arg is the text after the first ":"
+// in the transform's name, if any, e.g. "user" in `|json-field:user`.
+type Transform func(val, arg string) (string, error)
+
+// builtinTransforms are the transforms available in every reference, unless
+// replaced by WithTransform.
+var builtinTransforms = map[string]Transform{
+	"base64encode": func(val, _ string) (string, error) {
+		return base64.StdEncoding.EncodeToString([]byte(val)), nil
+	},
+	"base64decode": func(val, _ string) (string, error) {
+		val = strings.TrimSpace(val)
+		b, err := base64.StdEncoding.DecodeString(val)
+		if err != nil {
+			// also accept values without padding
+			b, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(val, "="))
+		}
+		return string(b), err
+	},
+	"hexencode": func(val, _ string) (string, error) {
+		return hex.EncodeToString([]byte(val)), nil
+	},
+	"hexdecode": func(val, _ string) (string, error) {
+		b, err := hex.DecodeString(strings.TrimSpace(val))
+		return string(b), err
+	},
+	"trim": func(val, _ string) (string, error) {
+		return strings.TrimSpace(val), nil
+	},
+	"lower": func(val, _ string) (string, error) {
+		return strings.ToLower(val), nil
+	},
+	"upper": func(val, _ string) (string, error) {
+		return strings.ToUpper(val), nil
+	},
+	"json-field": func(val, arg string) (string, error) {
+		if arg == "" {
+			return "", errors.New("missing field, e.g. json-field:name")
+		}
+		val, ok, err := reference{field: arg}.extract(val)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return "", errors.Errorf("field %q not found", arg)
+		}
+		return val, nil
+	},
+	"url-escape": func(val, _ string) (string, error) {
+		return url.QueryEscape(val), nil
+	},
+}
+
+// transformCall is a transform named in a reference, with its argument.
+type transformCall struct {
+	name string
+	arg  string
+}
+
+// parseTransforms parses the "|"-separated transforms at the end of a
+// reference, e.g. `base64decode|trim`.
+func parseTransforms(s string) ([]transformCall, error) {
+	names := strings.Split(s, "|")
+	calls := make([]transformCall, 0, len(names))
+	for _, name := range names {
+		if name == "" {
+			return nil, errors.New("empty transform")
+		}
+
+		var call transformCall
+		call.name, call.arg, _ = strings.Cut(name, ":")
+		calls = append(calls, call)
+	}
+	return calls, nil
+}
+
+// applyTransforms passes val through each of calls in turn, looking them up
+// in custom before builtinTransforms.
+func applyTransforms(val string, calls []transformCall, custom map[string]Transform) (string, error) {
+	for _, call := range calls {
+		fn, ok := custom[call.name]
+		if !ok {
+			fn, ok = builtinTransforms[call.name]
+		}
+		if !ok {
+			return "", errors.Errorf("unknown transform %q", call.name)
+		}
+
+		var err error
+		val, err = fn(val, call.arg)
+		if err != nil {
+			return "", errors.Wrapf(err, "transform %q", call.name)
+		}
+	}
+	return val, nil
+}
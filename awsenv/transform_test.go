@@ -0,0 +1,160 @@
+package awsenv
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyTransforms(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		input   string
+		calls   string
+		want    string
+		wantErr string
+	}{
+		{
+			name:  "base64encode",
+			input: "key",
+			calls: "base64encode",
+			want:  "a2V5",
+		},
+		{
+			name:  "base64decode",
+			input: "a2V5\n",
+			calls: "base64decode",
+			want:  "key",
+		},
+		{
+			name:  "base64decode_unpadded",
+			input: "a2V5cw",
+			calls: "base64decode",
+			want:  "keys",
+		},
+		{
+			name:  "hex",
+			input: "key",
+			calls: "hexencode|hexdecode",
+			want:  "key",
+		},
+		{
+			name:  "trim",
+			input: "  key\n",
+			calls: "trim",
+			want:  "key",
+		},
+		{
+			name:  "lower_upper",
+			input: "Key",
+			calls: "lower|upper",
+			want:  "KEY",
+		},
+		{
+			name:  "json_field",
+			input: `{"db":{"user":"app"}}`,
+			calls: "json-field:/db/user",
+			want:  "app",
+		},
+		{
+			name:  "url_escape",
+			input: "p@ss word/&",
+			calls: "url-escape",
+			want:  "p%40ss+word%2F%26",
+		},
+		{
+			name:  "pipeline",
+			input: "ICBrZXkKCg==",
+			calls: "base64decode|trim|upper",
+			want:  "KEY",
+		},
+		{
+			name:    "unknown",
+			input:   "key",
+			calls:   "rot13",
+			wantErr: `unknown transform "rot13"`,
+		},
+		{
+			name:    "invalid_base64",
+			input:   "not base64!",
+			calls:   "base64decode",
+			wantErr: `transform "base64decode": illegal base64 data at input byte 3`,
+		},
+		{
+			name:    "json_field_missing",
+			input:   `{"db":{}}`,
+			calls:   "json-field:/db/user",
+			wantErr: `transform "json-field": field "/db/user" not found`,
+		},
+		{
+			name:    "json_field_no_arg",
+			input:   `{}`,
+			calls:   "json-field",
+			wantErr: `transform "json-field": missing field, e.g. json-field:name`,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			calls, err := parseTransforms(test.calls)
+			require.NoError(t, err)
+
+			got, err := applyTransforms(test.input, calls, nil)
+			if test.wantErr != "" {
+				require.EqualError(t, err, test.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestReplacer_ReplaceAll_Transforms(t *testing.T) {
+	// Not parallel: this test mutates global environ/setenv via fakeEnv.install()
+	origEnviron := environ
+	origSetenv := setenv
+	t.Cleanup(func() {
+		environ = origEnviron
+		setenv = origSetenv
+	})
+
+	env := fakeEnv{
+		"TLS_KEY":  "awsenv:/tls/key|base64decode|trim",
+		"DB_URL":   "postgres://app:${awsenv:/db/pass|url-escape}@db/app",
+		"REVERSED": "awsenv:/db/user|reverse|upper",
+		"DEFAULT":  "awsenv:/missing?default=%20as%20is%20|trim",
+	}
+	env.install()
+
+	params := lenientParamStore{
+		"/tls/key": "LS0tLS1CRUdJTi0tLS0tCg==",
+		"/db/pass": "p@ss/word",
+		"/db/user": "app",
+	}
+
+	reverse := func(val, _ string) (string, error) {
+		var b strings.Builder
+		for i := len(val) - 1; i >= 0; i-- {
+			b.WriteByte(val[i])
+		}
+		return b.String(), nil
+	}
+
+	r := NewReplacer(DefaultPrefix, params, WithTransform("reverse", reverse))
+	err := r.ReplaceAll(context.Background())
+	require.NoError(t, err)
+
+	want := fakeEnv{
+		"TLS_KEY":  "-----BEGIN-----",
+		"DB_URL":   "postgres://app:p%40ss%2Fword@db/app",
+		"REVERSED": "PPA",
+		"DEFAULT":  " as is ",
+	}
+	require.Equal(t, want, env)
+}
@@ -0,0 +1,124 @@
+package v1
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/pkg/errors"
+
+	"github.com/sendgrid/aws-env/awsenv"
+	"github.com/sendgrid/aws-env/awsenv/internal/secrets"
+)
+
+// secretsManagerBatchGetSecretValueAPI defines the interface for the
+// BatchGetSecretValue function. We use this interface to test the function
+// using a mocked service.
+type secretsManagerBatchGetSecretValueAPI interface {
+	BatchGetSecretValueWithContext(ctx aws.Context,
+		input *secretsmanager.BatchGetSecretValueInput,
+		opts ...request.Option) (*secretsmanager.BatchGetSecretValueOutput, error)
+}
+
+// secretsManagerGetSecretValueAPI defines the interface for the
+// GetSecretValue function, used if BatchGetSecretValue cannot be.
+type secretsManagerGetSecretValueAPI interface {
+	GetSecretValueWithContext(ctx aws.Context,
+		input *secretsmanager.GetSecretValueInput,
+		opts ...request.Option) (*secretsmanager.GetSecretValueOutput, error)
+}
+
+// secretsManagerAPI is the part of a v1 secretsmanager client used by the
+// getter.
+type secretsManagerAPI interface {
+	secretsManagerBatchGetSecretValueAPI
+	secretsManagerGetSecretValueAPI
+}
+
+// NewSecretsGetter implements awsenv.ParamsGetter using a v1 secretsmanager
+// client. Names may be secret names or ARNs. Secrets are fetched with
+// BatchGetSecretValue, or one at a time with GetSecretValue if it is denied
+// or not supported.
+func NewSecretsGetter(sm secretsManagerAPI) awsenv.LimitedParamsGetter {
+	return &secretsFetcher{sm}
+}
+
+type secretsFetcher struct {
+	sm secretsManagerAPI
+}
+
+// GetParamsLimit returns the maximum number of secret ids accepted by a
+// single BatchGetSecretValue call.
+func (f *secretsFetcher) GetParamsLimit() int { return 20 }
+
+func (f *secretsFetcher) GetParams(ctx context.Context, names []string) (map[string]string, error) {
+	requested := secrets.Requested(names)
+
+	input := &secretsmanager.BatchGetSecretValueInput{
+		SecretIdList: aws.StringSlice(names),
+	}
+
+	var missing []string
+	m := make(map[string]string, len(names))
+	for {
+		resp, err := f.sm.BatchGetSecretValueWithContext(ctx, input)
+		if err != nil {
+			if aerr, ok := err.(awserr.Error); ok && secrets.BatchUnsupported(aerr.Code()) {
+				return f.getEach(ctx, names)
+			}
+			return nil, err
+		}
+
+		for _, apiErr := range resp.Errors {
+			// missing secrets are reported like invalid parameters
+			if aws.StringValue(apiErr.ErrorCode) == secrets.ErrCodeNotFound {
+				missing = append(missing, aws.StringValue(apiErr.SecretId))
+				continue
+			}
+			return nil, errors.Errorf("awsenv: secret %q: %s: %s",
+				aws.StringValue(apiErr.SecretId), aws.StringValue(apiErr.ErrorCode), aws.StringValue(apiErr.Message))
+		}
+
+		for _, secret := range resp.SecretValues {
+			m[secrets.Key(requested, aws.StringValue(secret.Name), aws.StringValue(secret.ARN))] = secrets.Value(secret.SecretString, secret.SecretBinary)
+		}
+
+		if resp.NextToken == nil {
+			break
+		}
+		input.NextToken = resp.NextToken
+	}
+
+	if len(missing) > 0 {
+		return m, &awsenv.InvalidParamsError{Names: missing}
+	}
+
+	return m, nil
+}
+
+// getEach fetches the secrets one at a time with GetSecretValue.
+func (f *secretsFetcher) getEach(ctx context.Context, names []string) (map[string]string, error) {
+	var missing []string
+	m := make(map[string]string, len(names))
+	for _, name := range names {
+		resp, err := f.sm.GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(name),
+		})
+		if err != nil {
+			if aerr, ok := err.(awserr.Error); ok && aerr.Code() == secrets.ErrCodeNotFound {
+				missing = append(missing, name)
+				continue
+			}
+			return nil, errors.Wrapf(err, "awsenv: secret %q", name)
+		}
+		m[name] = secrets.Value(resp.SecretString, resp.SecretBinary)
+	}
+
+	if len(missing) > 0 {
+		return m, &awsenv.InvalidParamsError{Names: missing}
+	}
+
+	return m, nil
+}
@@ -3,6 +3,7 @@ package v1
 
 import (
 	"context"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/request"
@@ -50,7 +51,68 @@ func (f *fetcher) GetParams(ctx context.Context, names []string) (map[string]str
 
 	m := make(map[string]string, len(resp.Parameters))
 	for _, param := range resp.Parameters {
-		m[*param.Name] = *param.Value
+		m[paramKey(aws.StringValue(param.Name), aws.StringValue(param.Selector))] = aws.StringValue(param.Value)
+	}
+
+	if len(resp.InvalidParameters) > 0 {
+		return m, &awsenv.InvalidParamsError{Names: aws.StringValueSlice(resp.InvalidParameters)}
+	}
+
+	return m, nil
+}
+
+// paramKey returns the name a parameter was requested by. SSM returns any
+// version or label selector (e.g. ":7" in "/path:7") separately from the name.
+func paramKey(name, selector string) string {
+	if selector == "" {
+		return name
+	}
+	if !strings.HasPrefix(selector, ":") {
+		selector = ":" + selector
+	}
+	return name + selector
+}
+
+// ssmGetParametersByPathAPI defines the interface for the GetParametersByPath
+// function. We use this interface to test the function using a mocked service.
+type ssmGetParametersByPathAPI interface {
+	GetParametersByPathWithContext(ctx aws.Context,
+		input *ssm.GetParametersByPathInput,
+		opts ...request.Option) (*ssm.GetParametersByPathOutput, error)
+}
+
+// NewPathGetter implements awsenv.PathGetter using a v1 ssm client.
+func NewPathGetter(ssm ssmGetParametersByPathAPI) awsenv.PathGetter {
+	return &pathFetcher{ssm, true}
+}
+
+type pathFetcher struct {
+	ssm     ssmGetParametersByPathAPI
+	decrypt bool
+}
+
+func (f *pathFetcher) GetParamsByPath(ctx context.Context, path string, recursive bool) (map[string]string, error) {
+	input := &ssm.GetParametersByPathInput{
+		Path:           &path,
+		Recursive:      &recursive,
+		WithDecryption: &f.decrypt,
+	}
+
+	m := make(map[string]string)
+	for {
+		resp, err := f.ssm.GetParametersByPathWithContext(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, param := range resp.Parameters {
+			m[aws.StringValue(param.Name)] = aws.StringValue(param.Value)
+		}
+
+		if resp.NextToken == nil {
+			break
+		}
+		input.NextToken = resp.NextToken
 	}
 
 	return m, nil
@@ -0,0 +1,29 @@
+package v2
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// ExpiryWindow is how long before they expire credentials from AssumeRole
+// are refreshed, so that no request is signed with credentials about to
+// expire.
+const ExpiryWindow = 5 * time.Minute
+
+// AssumeRole returns a copy of cfg whose credentials are those of the role
+// roleARN, assumed with the credentials of cfg. The role is assumed again
+// shortly before its credentials expire, so that long-running processes
+// keep working. stsOptFns configure the STS client assuming the role, e.g.
+// to set its endpoint.
+func AssumeRole(cfg aws.Config, roleARN string, stsOptFns []func(*sts.Options), optFns ...func(*stscreds.AssumeRoleOptions)) aws.Config {
+	provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg, stsOptFns...), roleARN, optFns...)
+
+	cfg = cfg.Copy()
+	cfg.Credentials = aws.NewCredentialsCache(provider, func(o *aws.CredentialsCacheOptions) {
+		o.ExpiryWindow = ExpiryWindow
+	})
+	return cfg
+}
@@ -0,0 +1,79 @@
+package v2
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSTS is a stand-in for STS, handling AssumeRole. Each call returns new
+// credentials expiring at the next time in expires, or in an hour once
+// there are none left.
+type fakeSTS struct {
+	mu      sync.Mutex
+	calls   int
+	expires []time.Duration
+}
+
+func (f *fakeSTS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.calls++
+	call := f.calls
+	expiresIn := time.Hour
+	if len(f.expires) > 0 {
+		expiresIn, f.expires = f.expires[0], f.expires[1:]
+	}
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/xml")
+	fmt.Fprintf(w, `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>ASIA%[1]d</AccessKeyId>
+      <SecretAccessKey>secret</SecretAccessKey>
+      <SessionToken>token</SessionToken>
+      <Expiration>%[2]s</Expiration>
+    </Credentials>
+  </AssumeRoleResult>
+</AssumeRoleResponse>`, call, time.Now().Add(expiresIn).UTC().Format(time.RFC3339))
+}
+
+func TestAssumeRole_refresh(t *testing.T) {
+	t.Parallel()
+	// the first credentials are already inside the expiry window
+	fake := &fakeSTS{expires: []time.Duration{ExpiryWindow - time.Minute}}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	cfg := aws.Config{
+		Region:      "us-east-1",
+		Credentials: credentials.NewStaticCredentialsProvider("AKID", "secret", ""),
+	}
+	withEndpoint := func(o *sts.Options) { o.BaseEndpoint = aws.String(srv.URL) }
+	cfg = AssumeRole(cfg, "arn:aws:iam::111122223333:role/app", []func(*sts.Options){withEndpoint})
+	ctx := context.Background()
+
+	creds, err := cfg.Credentials.Retrieve(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "ASIA1", creds.AccessKeyID)
+
+	// expired, so the role is assumed again
+	creds, err = cfg.Credentials.Retrieve(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "ASIA2", creds.AccessKeyID)
+
+	// still valid, so cached
+	creds, err = cfg.Credentials.Retrieve(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "ASIA2", creds.AccessKeyID)
+	require.Equal(t, 2, fake.calls)
+}
@@ -0,0 +1,125 @@
+package v2
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/smithy-go"
+	"github.com/pkg/errors"
+
+	"github.com/sendgrid/aws-env/awsenv"
+	"github.com/sendgrid/aws-env/awsenv/internal/secrets"
+)
+
+// secretsManagerBatchGetSecretValueAPI defines the interface for the
+// BatchGetSecretValue function. We use this interface to test the function
+// using a mocked service.
+type secretsManagerBatchGetSecretValueAPI interface {
+	BatchGetSecretValue(ctx context.Context,
+		params *secretsmanager.BatchGetSecretValueInput,
+		optFns ...func(*secretsmanager.Options)) (*secretsmanager.BatchGetSecretValueOutput, error)
+}
+
+// secretsManagerGetSecretValueAPI defines the interface for the
+// GetSecretValue function, used if BatchGetSecretValue cannot be.
+type secretsManagerGetSecretValueAPI interface {
+	GetSecretValue(ctx context.Context,
+		params *secretsmanager.GetSecretValueInput,
+		optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error)
+}
+
+// secretsManagerAPI is the part of a v2 secretsmanager client used by the
+// getter.
+type secretsManagerAPI interface {
+	secretsManagerBatchGetSecretValueAPI
+	secretsManagerGetSecretValueAPI
+}
+
+// NewSecretsGetter implements awsenv.ParamsGetter using a v2 secretsmanager
+// client. Names may be secret names or ARNs. Secrets are fetched with
+// BatchGetSecretValue, or one at a time with GetSecretValue if it is denied
+// or not supported.
+func NewSecretsGetter(sm secretsManagerAPI) awsenv.LimitedParamsGetter {
+	return &secretsFetcher{sm}
+}
+
+type secretsFetcher struct {
+	sm secretsManagerAPI
+}
+
+// GetParamsLimit returns the maximum number of secret ids accepted by a
+// single BatchGetSecretValue call.
+func (f *secretsFetcher) GetParamsLimit() int { return 20 }
+
+func (f *secretsFetcher) GetParams(ctx context.Context, names []string) (map[string]string, error) {
+	requested := secrets.Requested(names)
+
+	input := &secretsmanager.BatchGetSecretValueInput{
+		SecretIdList: names,
+	}
+
+	var missing []string
+	m := make(map[string]string, len(names))
+	for {
+		resp, err := f.sm.BatchGetSecretValue(ctx, input)
+		if err != nil {
+			var apiErr smithy.APIError
+			if errors.As(err, &apiErr) && secrets.BatchUnsupported(apiErr.ErrorCode()) {
+				return f.getEach(ctx, names)
+			}
+			return nil, err
+		}
+
+		for _, apiErr := range resp.Errors {
+			// missing secrets are reported like invalid parameters
+			if aws.ToString(apiErr.ErrorCode) == secrets.ErrCodeNotFound {
+				missing = append(missing, aws.ToString(apiErr.SecretId))
+				continue
+			}
+			return nil, errors.Errorf("awsenv: secret %q: %s: %s",
+				aws.ToString(apiErr.SecretId), aws.ToString(apiErr.ErrorCode), aws.ToString(apiErr.Message))
+		}
+
+		for _, secret := range resp.SecretValues {
+			m[secrets.Key(requested, aws.ToString(secret.Name), aws.ToString(secret.ARN))] = secrets.Value(secret.SecretString, secret.SecretBinary)
+		}
+
+		if resp.NextToken == nil {
+			break
+		}
+		input.NextToken = resp.NextToken
+	}
+
+	if len(missing) > 0 {
+		return m, &awsenv.InvalidParamsError{Names: missing}
+	}
+
+	return m, nil
+}
+
+// getEach fetches the secrets one at a time with GetSecretValue.
+func (f *secretsFetcher) getEach(ctx context.Context, names []string) (map[string]string, error) {
+	var missing []string
+	m := make(map[string]string, len(names))
+	for _, name := range names {
+		resp, err := f.sm.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(name),
+		})
+		if err != nil {
+			var apiErr smithy.APIError
+			if errors.As(err, &apiErr) && apiErr.ErrorCode() == secrets.ErrCodeNotFound {
+				missing = append(missing, name)
+				continue
+			}
+			return nil, errors.Wrapf(err, "awsenv: secret %q", name)
+		}
+		m[name] = secrets.Value(resp.SecretString, resp.SecretBinary)
+	}
+
+	if len(missing) > 0 {
+		return m, &awsenv.InvalidParamsError{Names: missing}
+	}
+
+	return m, nil
+}
@@ -0,0 +1,143 @@
+package v2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/aws/smithy-go"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sendgrid/aws-env/awsenv"
+)
+
+type mockSecretsManager func(*secretsmanager.BatchGetSecretValueInput) (*secretsmanager.BatchGetSecretValueOutput, error)
+
+func (f mockSecretsManager) BatchGetSecretValue(_ context.Context, input *secretsmanager.BatchGetSecretValueInput, _ ...func(*secretsmanager.Options)) (*secretsmanager.BatchGetSecretValueOutput, error) {
+	return f(input)
+}
+
+func (f mockSecretsManager) GetSecretValue(context.Context, *secretsmanager.GetSecretValueInput, ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+	return nil, errors.New("unexpected GetSecretValue call")
+}
+
+// batchlessSecretsManager holds secrets that can only be fetched with
+// GetSecretValue, BatchGetSecretValue failing with its error code.
+type batchlessSecretsManager struct {
+	code    string
+	secrets map[string]string
+}
+
+func (m batchlessSecretsManager) BatchGetSecretValue(context.Context, *secretsmanager.BatchGetSecretValueInput, ...func(*secretsmanager.Options)) (*secretsmanager.BatchGetSecretValueOutput, error) {
+	return nil, &smithy.GenericAPIError{Code: m.code, Message: "batch failed"}
+}
+
+func (m batchlessSecretsManager) GetSecretValue(_ context.Context, input *secretsmanager.GetSecretValueInput, _ ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+	id := aws.ToString(input.SecretId)
+	if id == "prod/denied" {
+		return nil, &smithy.GenericAPIError{Code: "AccessDeniedException", Message: "denied"}
+	}
+	val, ok := m.secrets[id]
+	if !ok {
+		return nil, &types.ResourceNotFoundException{Message: aws.String("not found")}
+	}
+	return &secretsmanager.GetSecretValueOutput{Name: aws.String(id), SecretString: aws.String(val)}, nil
+}
+
+func TestSecretsGetter_GetParams(t *testing.T) {
+	t.Parallel()
+	const arn = "arn:aws:secretsmanager:us-east-1:123456789012:secret:remote/db-AbCdEf"
+
+	sm := mockSecretsManager(func(input *secretsmanager.BatchGetSecretValueInput) (*secretsmanager.BatchGetSecretValueOutput, error) {
+		require.Equal(t, []string{"prod/db", arn, "prod/missing"}, input.SecretIdList)
+		return &secretsmanager.BatchGetSecretValueOutput{
+			SecretValues: []types.SecretValueEntry{
+				{Name: aws.String("prod/db"), ARN: aws.String("arn:aws:secretsmanager:us-east-1:123456789012:secret:prod/db-XyZ123"), SecretString: aws.String("local")},
+				{Name: aws.String("remote/db"), ARN: aws.String(arn), SecretBinary: []byte("remote")},
+			},
+			Errors: []types.APIErrorType{
+				{SecretId: aws.String("prod/missing"), ErrorCode: aws.String("ResourceNotFoundException")},
+			},
+		}, nil
+	})
+
+	got, err := NewSecretsGetter(sm).GetParams(context.Background(), []string{"prod/db", arn, "prod/missing"})
+	require.Equal(t, &awsenv.InvalidParamsError{Names: []string{"prod/missing"}}, err)
+	require.Equal(t, map[string]string{"prod/db": "local", arn: "remote"}, got)
+}
+
+func TestSecretsGetter_GetParams_limit(t *testing.T) {
+	t.Parallel()
+	var (
+		mu    sync.Mutex
+		sizes []int
+	)
+	sm := mockSecretsManager(func(input *secretsmanager.BatchGetSecretValueInput) (*secretsmanager.BatchGetSecretValueOutput, error) {
+		mu.Lock()
+		sizes = append(sizes, len(input.SecretIdList))
+		mu.Unlock()
+
+		out := &secretsmanager.BatchGetSecretValueOutput{}
+		for _, id := range input.SecretIdList {
+			out.SecretValues = append(out.SecretValues, types.SecretValueEntry{Name: aws.String(id), SecretString: aws.String(id + "-value")})
+		}
+		return out, nil
+	})
+
+	names := make([]string, 25)
+	want := make(map[string]string, len(names))
+	for i := range names {
+		names[i] = fmt.Sprintf("prod/secret-%d", i)
+		want[names[i]] = names[i] + "-value"
+	}
+
+	got, err := awsenv.NewRouter(NewSecretsGetter(sm)).GetParams(context.Background(), names)
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+
+	sort.Ints(sizes)
+	require.Equal(t, []int{5, 20}, sizes)
+}
+
+func TestSecretsGetter_GetParams_error(t *testing.T) {
+	t.Parallel()
+	sm := mockSecretsManager(func(*secretsmanager.BatchGetSecretValueInput) (*secretsmanager.BatchGetSecretValueOutput, error) {
+		return &secretsmanager.BatchGetSecretValueOutput{
+			Errors: []types.APIErrorType{
+				{SecretId: aws.String("prod/db"), ErrorCode: aws.String("AccessDeniedException"), Message: aws.String("denied")},
+			},
+		}, nil
+	})
+
+	_, err := NewSecretsGetter(sm).GetParams(context.Background(), []string{"prod/db"})
+	require.EqualError(t, err, `awsenv: secret "prod/db": AccessDeniedException: denied`)
+}
+
+func TestSecretsGetter_GetParams_fallback(t *testing.T) {
+	t.Parallel()
+	for _, code := range []string{"AccessDeniedException", "UnknownOperationException"} {
+		code := code
+		t.Run(code, func(t *testing.T) {
+			t.Parallel()
+			sm := batchlessSecretsManager{code: code, secrets: map[string]string{"prod/db": "local"}}
+
+			got, err := NewSecretsGetter(sm).GetParams(context.Background(), []string{"prod/db", "prod/missing"})
+			require.Equal(t, &awsenv.InvalidParamsError{Names: []string{"prod/missing"}}, err)
+			require.Equal(t, map[string]string{"prod/db": "local"}, got)
+
+			_, err = NewSecretsGetter(sm).GetParams(context.Background(), []string{"prod/db", "prod/denied"})
+			require.EqualError(t, err, `awsenv: secret "prod/denied": api error AccessDeniedException: denied`)
+		})
+	}
+
+	// other errors are returned as they are
+	sm := batchlessSecretsManager{code: "ThrottlingException"}
+	_, err := NewSecretsGetter(sm).GetParams(context.Background(), []string{"prod/db"})
+	require.EqualError(t, err, "api error ThrottlingException: batch failed")
+}
@@ -3,7 +3,9 @@ package v2
 
 import (
 	"context"
+	"strings"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/ssm"
 
@@ -43,7 +45,68 @@ func (f *fetcher) GetParams(ctx context.Context, names []string) (map[string]str
 
 	m := make(map[string]string, len(resp.Parameters))
 	for _, param := range resp.Parameters {
-		m[*param.Name] = *param.Value
+		m[paramKey(aws.ToString(param.Name), aws.ToString(param.Selector))] = aws.ToString(param.Value)
+	}
+
+	if len(resp.InvalidParameters) > 0 {
+		return m, &awsenv.InvalidParamsError{Names: resp.InvalidParameters}
+	}
+
+	return m, nil
+}
+
+// paramKey returns the name a parameter was requested by. SSM returns any
+// version or label selector (e.g. ":7" in "/path:7") separately from the name.
+func paramKey(name, selector string) string {
+	if selector == "" {
+		return name
+	}
+	if !strings.HasPrefix(selector, ":") {
+		selector = ":" + selector
+	}
+	return name + selector
+}
+
+// ssmGetParametersByPathAPI defines the interface for the GetParametersByPath
+// function. We use this interface to test the function using a mocked service.
+type ssmGetParametersByPathAPI interface {
+	GetParametersByPath(ctx context.Context,
+		params *ssm.GetParametersByPathInput,
+		optFns ...func(*ssm.Options)) (*ssm.GetParametersByPathOutput, error)
+}
+
+// NewPathGetter implements awsenv.PathGetter using a v2 ssm client.
+func NewPathGetter(ssm ssmGetParametersByPathAPI) awsenv.PathGetter {
+	return &pathFetcher{ssm, true}
+}
+
+type pathFetcher struct {
+	ssm     ssmGetParametersByPathAPI
+	decrypt bool
+}
+
+func (f *pathFetcher) GetParamsByPath(ctx context.Context, path string, recursive bool) (map[string]string, error) {
+	input := &ssm.GetParametersByPathInput{
+		Path:           &path,
+		Recursive:      &recursive,
+		WithDecryption: &f.decrypt,
+	}
+
+	m := make(map[string]string)
+	for {
+		resp, err := f.ssm.GetParametersByPath(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, param := range resp.Parameters {
+			m[aws.ToString(param.Name)] = aws.ToString(param.Value)
+		}
+
+		if resp.NextToken == nil {
+			break
+		}
+		input.NextToken = resp.NextToken
 	}
 
 	return m, nil
@@ -0,0 +1,63 @@
+package v2
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sendgrid/aws-env/awsenv"
+)
+
+type mockSSM func(*ssm.GetParametersInput) (*ssm.GetParametersOutput, error)
+
+func (f mockSSM) GetParameters(_ context.Context, input *ssm.GetParametersInput, _ ...func(*ssm.Options)) (*ssm.GetParametersOutput, error) {
+	return f(input)
+}
+
+func TestParamsGetter_GetParams_selectors(t *testing.T) {
+	t.Parallel()
+	client := mockSSM(func(input *ssm.GetParametersInput) (*ssm.GetParametersOutput, error) {
+		require.Equal(t, []string{"/path", "/path:7", "/path:prod-label"}, input.Names)
+		return &ssm.GetParametersOutput{
+			Parameters: []types.Parameter{
+				{Name: aws.String("/path"), Value: aws.String("latest")},
+				{Name: aws.String("/path"), Selector: aws.String(":7"), Value: aws.String("v7")},
+				{Name: aws.String("/path"), Selector: aws.String(":prod-label"), Value: aws.String("prod")},
+			},
+		}, nil
+	})
+
+	got, err := NewParamsGetter(client).GetParams(context.Background(), []string{"/path", "/path:7", "/path:prod-label"})
+	require.NoError(t, err)
+
+	want := map[string]string{
+		"/path":            "latest",
+		"/path:7":          "v7",
+		"/path:prod-label": "prod",
+	}
+	require.Equal(t, want, got)
+}
+
+func TestParamsGetter_GetParams_invalid(t *testing.T) {
+	t.Parallel()
+	client := mockSSM(func(input *ssm.GetParametersInput) (*ssm.GetParametersOutput, error) {
+		return &ssm.GetParametersOutput{
+			Parameters: []types.Parameter{
+				{Name: aws.String("/path"), Value: aws.String("latest")},
+			},
+			InvalidParameters: []string{"/missing"},
+		}, nil
+	})
+
+	got, err := NewParamsGetter(client).GetParams(context.Background(), []string{"/path", "/missing"})
+	require.Equal(t, map[string]string{"/path": "latest"}, got)
+
+	var invalid *awsenv.InvalidParamsError
+	require.True(t, errors.As(err, &invalid), "expected an *InvalidParamsError, got %v", err)
+	require.Equal(t, []string{"/missing"}, invalid.Names)
+}
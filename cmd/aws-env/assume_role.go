@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+	v2 "github.com/sendgrid/aws-env/awsenv/v2"
+	log "github.com/sirupsen/logrus"
+)
+
+// assumeRoleSessionName is the role session name used when there is no
+// command to name it after.
+const assumeRoleSessionName = "awsenv_assume_role_session"
+
+// mfaInput is where --mfa-token-stdin reads the MFA token from.
+var mfaInput io.Reader = os.Stdin
+
+// maxSessionNameLen is the longest role session name STS accepts.
+const maxSessionNameLen = 64
+
+// invalidSessionNameChars matches the characters STS does not accept in a
+// role session name.
+var invalidSessionNameChars = regexp.MustCompile(`[^\w+=,.@-]`)
+
+// assumeRoles assumes each role of the --assume-role chain in turn, each with
+// the credentials of the one before, starting with those in cfg. Each role is
+// assumed again before its credentials expire. The MFA token, if any, is used
+// for the first role only. prog is the command being run, which names the
+// role session unless --role-session-name is set.
+func assumeRoles(ctx context.Context, cfg aws.Config, prog string) (aws.CredentialsProvider, error) {
+	roles, err := parseRoleChain(assumeRole)
+	if err != nil {
+		return nil, err
+	}
+
+	tags, err := parseSessionTags(sessionTags)
+	if err != nil {
+		return nil, err
+	}
+
+	if mfaSerial == "" && (mfaToken != "" || mfaTokenStdin) {
+		return nil, errors.New("--mfa-token and --mfa-token-stdin require --mfa-serial")
+	}
+	if mfaSerial != "" && mfaToken == "" && !mfaTokenStdin {
+		return nil, errors.New("--mfa-serial requires --mfa-token or --mfa-token-stdin")
+	}
+
+	name := sessionName(prog)
+	for i, role := range roles {
+		first := i == 0
+		cfg = v2.AssumeRole(cfg, role, stsOptions(), func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = name
+			o.Tags = tags
+			if assumeRoleDuration != 0 {
+				o.Duration = assumeRoleDuration
+			}
+			if externalID != "" {
+				o.ExternalID = aws.String(externalID)
+			}
+			if sourceIdentity != "" {
+				o.SourceIdentity = aws.String(sourceIdentity)
+			}
+			if first && mfaSerial != "" {
+				o.SerialNumber = aws.String(mfaSerial)
+				o.TokenProvider = mfaTokenProvider()
+			}
+		})
+	}
+
+	// fail early, as the roles are otherwise only assumed once a parameter
+	// is fetched
+	if _, err := cfg.Credentials.Retrieve(ctx); err != nil {
+		log.WithFields(log.Fields{
+			"assume_role": assumeRole,
+		}).Error("unable to assume role")
+		return nil, err
+	}
+
+	log.WithFields(log.Fields{
+		"assume_role":  assumeRole,
+		"session_name": name,
+	}).Info("assumed role")
+
+	return cfg.Credentials, nil
+}
+
+// parseRoleChain parses the comma-separated role ARNs of the --assume-role
+// flag.
+func parseRoleChain(val string) ([]string, error) {
+	roles := strings.Split(val, ",")
+	for i, role := range roles {
+		role = strings.TrimSpace(role)
+		if role == "" {
+			return nil, fmt.Errorf("invalid role chain %q, must be comma-separated role ARNs", val)
+		}
+		roles[i] = role
+	}
+
+	return roles, nil
+}
+
+// parseSessionTags parses the KEY=VALUE values of the --session-tag flag.
+func parseSessionTags(vals []string) ([]types.Tag, error) {
+	if len(vals) == 0 {
+		return nil, nil
+	}
+
+	tags := make(map[string]string, len(vals))
+	for _, val := range vals {
+		key, value, ok := strings.Cut(val, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid session tag %q, must be KEY=VALUE", val)
+		}
+		tags[key] = value
+	}
+
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	out := make([]types.Tag, 0, len(keys))
+	for _, key := range keys {
+		out = append(out, types.Tag{Key: aws.String(key), Value: aws.String(tags[key])})
+	}
+
+	return out, nil
+}
+
+// sessionName returns the role session name given by --role-session-name.
+// Otherwise it is derived from the name of prog, so that CloudTrail shows
+// which command the role was assumed for.
+func sessionName(prog string) string {
+	if roleSessionName != "" {
+		return roleSessionName
+	}
+	if prog == "" {
+		return assumeRoleSessionName
+	}
+
+	name := "aws-env-" + invalidSessionNameChars.ReplaceAllString(filepath.Base(prog), "-")
+	if len(name) > maxSessionNameLen {
+		name = name[:maxSessionNameLen]
+	}
+	return name
+}
+
+// errMFARenew is returned when the role assumed with MFA is about to expire.
+var errMFARenew = errors.New("the role assumed with --mfa-serial cannot be renewed without a new MFA token, use a longer --assume-role-duration")
+
+// mfaTokenProvider returns the MFA token given by --mfa-token, or reads it
+// from mfaInput if --mfa-token-stdin is set. The token is provided once
+// only: MFA tokens are single-use, and stdin belongs to the command once it
+// runs, so renewing the role fails with errMFARenew instead.
+func mfaTokenProvider() func() (string, error) {
+	var used int32
+	return func() (string, error) {
+		if !atomic.CompareAndSwapInt32(&used, 0, 1) {
+			return "", errMFARenew
+		}
+		if !mfaTokenStdin {
+			return mfaToken, nil
+		}
+
+		fmt.Fprintf(os.Stderr, "MFA token for %s: ", mfaSerial)
+		return readMFAToken(mfaInput)
+	}
+}
+
+// readMFAToken reads an MFA token from the first line of r. Only that line
+// is read, so that the rest is left for the command.
+func readMFAToken(r io.Reader) (string, error) {
+	var b strings.Builder
+	buf := make([]byte, 1)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if buf[0] == '\n' {
+				break
+			}
+			b.WriteByte(buf[0])
+		}
+		if errors.Is(err, io.EOF) {
+			if b.Len() == 0 {
+				return "", errors.New("no MFA token on stdin")
+			}
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return strings.TrimSpace(b.String()), nil
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/stretchr/testify/require"
+
+	v2 "github.com/sendgrid/aws-env/awsenv/v2"
+)
+
+func TestLoadAWSConfig_roleChain(t *testing.T) {
+	isolateAWSEnv(t)
+	sts := &fakeSTS{}
+	sts.install(t)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDENV")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	assumeRole = "arn:aws:iam::111122223333:role/jump, arn:aws:iam::444455556666:role/ssm-reader"
+	externalID = "ext-123"
+	assumeRoleDuration = time.Hour
+	sessionTags = []string{"team=payments", "env=prod"}
+	sourceIdentity = "alice"
+
+	ctx := context.Background()
+	cfg, err := loadAWSConfig(ctx, "/usr/local/bin/my-app")
+	require.NoError(t, err)
+	creds, err := cfg.Credentials.Retrieve(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "ASIASSM-READER", creds.AccessKeyID)
+
+	hop := stsCall{
+		Action:         "AssumeRole",
+		SessionName:    "aws-env-my-app",
+		ExternalID:     "ext-123",
+		Duration:       "3600",
+		SourceIdentity: "alice",
+		Tags:           map[string]string{"team": "payments", "env": "prod"},
+	}
+	first, second := hop, hop
+	first.RoleARN, first.CallerKey = "arn:aws:iam::111122223333:role/jump", "AKIDENV"
+	second.RoleARN, second.CallerKey = "arn:aws:iam::444455556666:role/ssm-reader", "ASIAJUMP"
+	require.Equal(t, []stsCall{first, second}, sts.Calls())
+}
+
+func TestLoadAWSConfig_refresh(t *testing.T) {
+	isolateAWSEnv(t)
+	// the first credentials of both roles are already inside the expiry
+	// window, as they would be after running for a while
+	soon := v2.ExpiryWindow - time.Minute
+	sts := &fakeSTS{expires: []time.Duration{soon, soon}}
+	sts.install(t)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDENV")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	assumeRole = "arn:aws:iam::111122223333:role/jump,arn:aws:iam::444455556666:role/ssm-reader"
+
+	ctx := context.Background()
+	cfg, err := loadAWSConfig(ctx, "my-app")
+	require.NoError(t, err)
+	require.Len(t, sts.Calls(), 2)
+
+	// both roles are assumed again, in order
+	creds, err := cfg.Credentials.Retrieve(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "ASIASSM-READER", creds.AccessKeyID)
+	require.True(t, creds.Expires.After(time.Now().Add(v2.ExpiryWindow)))
+
+	calls := sts.Calls()
+	require.Len(t, calls, 4)
+	require.Equal(t, "arn:aws:iam::111122223333:role/jump", calls[2].RoleARN)
+	require.Equal(t, "AKIDENV", calls[2].CallerKey)
+	require.Equal(t, "arn:aws:iam::444455556666:role/ssm-reader", calls[3].RoleARN)
+	require.Equal(t, "ASIAJUMP", calls[3].CallerKey)
+
+	// and are then cached until they are about to expire
+	_, err = cfg.Credentials.Retrieve(ctx)
+	require.NoError(t, err)
+	require.Len(t, sts.Calls(), 4)
+}
+
+func TestLoadAWSConfig_mfa(t *testing.T) {
+	isolateAWSEnv(t)
+	sts := &fakeSTS{}
+	sts.install(t)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDENV")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	origInput := mfaInput
+	t.Cleanup(func() { mfaInput = origInput })
+	stdin := strings.NewReader("123456\nfor the command\n")
+	mfaInput = stdin
+
+	assumeRole = "arn:aws:iam::111122223333:role/jump,arn:aws:iam::444455556666:role/ssm-reader"
+	roleSessionName = "deploy-42"
+	mfaSerial = "arn:aws:iam::111122223333:mfa/alice"
+	mfaTokenStdin = true
+
+	_, err := loadAWSConfig(context.Background(), "my-app")
+	require.NoError(t, err)
+
+	// only the first role is assumed with MFA
+	calls := sts.Calls()
+	require.Len(t, calls, 2)
+	require.Equal(t, "deploy-42", calls[0].SessionName)
+	require.Equal(t, "arn:aws:iam::111122223333:mfa/alice", calls[0].SerialNumber)
+	require.Equal(t, "123456", calls[0].TokenCode)
+	require.Empty(t, calls[1].SerialNumber)
+	require.Empty(t, calls[1].TokenCode)
+
+	// the rest of stdin is left for the command
+	rest, err := ioutil.ReadAll(stdin)
+	require.NoError(t, err)
+	require.Equal(t, "for the command\n", string(rest))
+}
+
+func TestLoadAWSConfig_mfaRefresh(t *testing.T) {
+	tests := []struct {
+		name  string
+		token string
+		stdin bool
+	}{
+		{name: "token", token: "123456"},
+		{name: "stdin", stdin: true},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			isolateAWSEnv(t)
+			// the role assumed with MFA is already inside the expiry window
+			sts := &fakeSTS{expires: []time.Duration{v2.ExpiryWindow - time.Minute}}
+			sts.install(t)
+			t.Setenv("AWS_ACCESS_KEY_ID", "AKIDENV")
+			t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+			origInput := mfaInput
+			t.Cleanup(func() { mfaInput = origInput })
+			stdin := strings.NewReader("123456\nfor the command\n")
+			mfaInput = stdin
+
+			assumeRole = "arn:aws:iam::111122223333:role/jump"
+			mfaSerial = "arn:aws:iam::111122223333:mfa/alice"
+			mfaToken, mfaTokenStdin = test.token, test.stdin
+
+			ctx := context.Background()
+			cfg, err := loadAWSConfig(ctx, "my-app")
+			require.NoError(t, err)
+			require.Len(t, sts.Calls(), 1)
+
+			// neither the token nor stdin is used again
+			_, err = cfg.Credentials.Retrieve(ctx)
+			require.ErrorIs(t, err, errMFARenew)
+			require.Len(t, sts.Calls(), 1)
+
+			rest, err := ioutil.ReadAll(stdin)
+			require.NoError(t, err)
+			want := "for the command\n"
+			if !test.stdin {
+				want = "123456\n" + want
+			}
+			require.Equal(t, want, string(rest))
+		})
+	}
+}
+
+func TestLoadAWSConfig_mfaInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		serial  string
+		token   string
+		stdin   bool
+		wantErr string
+	}{
+		{
+			name:    "no_token",
+			serial:  "arn:aws:iam::111122223333:mfa/alice",
+			wantErr: "--mfa-serial requires --mfa-token or --mfa-token-stdin",
+		},
+		{
+			name:    "no_serial",
+			token:   "123456",
+			wantErr: "--mfa-token and --mfa-token-stdin require --mfa-serial",
+		},
+		{
+			name:    "no_serial_stdin",
+			stdin:   true,
+			wantErr: "--mfa-token and --mfa-token-stdin require --mfa-serial",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			isolateAWSEnv(t)
+			t.Setenv("AWS_ACCESS_KEY_ID", "AKIDENV")
+			t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+			assumeRole = "arn:aws:iam::111122223333:role/jump"
+			mfaSerial, mfaToken, mfaTokenStdin = test.serial, test.token, test.stdin
+
+			_, err := loadAWSConfig(context.Background(), "")
+			require.EqualError(t, err, test.wantErr)
+		})
+	}
+}
+
+func TestParseRoleChain(t *testing.T) {
+	t.Parallel()
+	got, err := parseRoleChain("arn:aws:iam::111122223333:role/a , arn:aws:iam::444455556666:role/b")
+	require.NoError(t, err)
+	require.Equal(t, []string{"arn:aws:iam::111122223333:role/a", "arn:aws:iam::444455556666:role/b"}, got)
+
+	_, err = parseRoleChain("arn:aws:iam::111122223333:role/a,,arn:aws:iam::444455556666:role/b")
+	require.EqualError(t, err, `invalid role chain "arn:aws:iam::111122223333:role/a,,arn:aws:iam::444455556666:role/b", must be comma-separated role ARNs`)
+}
+
+func TestParseSessionTags(t *testing.T) {
+	t.Parallel()
+	got, err := parseSessionTags([]string{"team=payments", "note=a=b", "empty="})
+	require.NoError(t, err)
+	require.Equal(t, []types.Tag{
+		{Key: aws.String("empty"), Value: aws.String("")},
+		{Key: aws.String("note"), Value: aws.String("a=b")},
+		{Key: aws.String("team"), Value: aws.String("payments")},
+	}, got)
+
+	_, err = parseSessionTags([]string{"=payments"})
+	require.EqualError(t, err, `invalid session tag "=payments", must be KEY=VALUE`)
+}
+
+func TestSessionName(t *testing.T) {
+	tests := []struct {
+		flag string
+		prog string
+		want string
+	}{
+		{prog: "", want: assumeRoleSessionName},
+		{prog: "my-app", want: "aws-env-my-app"},
+		{prog: "/srv/bin/my-app", want: "aws-env-my-app"},
+		{prog: "./run app!.sh", want: "aws-env-run-app-.sh"},
+		{prog: strings.Repeat("x", 80), want: "aws-env-" + strings.Repeat("x", 56)},
+		{flag: "deploy-42", prog: "my-app", want: "deploy-42"},
+	}
+
+	origSessionName := roleSessionName
+	t.Cleanup(func() { roleSessionName = origSessionName })
+
+	for _, test := range tests {
+		roleSessionName = test.flag
+		require.Equal(t, test.want, sessionName(test.prog), test.prog)
+	}
+}
+
+func TestReadMFAToken(t *testing.T) {
+	t.Parallel()
+	got, err := readMFAToken(strings.NewReader(" 123456\r\n"))
+	require.NoError(t, err)
+	require.Equal(t, "123456", got)
+
+	got, err = readMFAToken(strings.NewReader("654321"))
+	require.NoError(t, err)
+	require.Equal(t, "654321", got)
+
+	_, err = readMFAToken(strings.NewReader(""))
+	require.EqualError(t, err, "no MFA token on stdin")
+}
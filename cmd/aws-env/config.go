@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go-v2/credentials/endpointcreds"
+	"github.com/aws/aws-sdk-go-v2/credentials/processcreds"
+	"github.com/aws/aws-sdk-go-v2/credentials/ssocreds"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultRegion is the default of the --region flag. It is also used when
+// the flag is set empty and no region is set by the environment or the
+// shared config files.
+const defaultRegion = "us-east-1"
+
+// loadAWSConfig loads the SDK configuration the way the AWS CLI does: from
+// the environment, the shared config and credentials files (including SSO
+// profiles, credential_process and source_profile role chains), web identity
+// tokens (EKS IAM roles for service accounts), container credentials (ECS
+// and EKS Pod Identity) and finally the EC2 instance metadata service. The
+// --region and --profile flags override the region and profile found there;
+// only an empty --region lets the environment or profile choose the region.
+// --assume-role assumes roles with the credentials found. prog is the
+// command being run, if any.
+func loadAWSConfig(ctx context.Context, prog string) (aws.Config, error) {
+	opts, err := endpointOptions()
+	if err != nil {
+		return aws.Config{}, err
+	}
+
+	if region != "" {
+		opts = append(opts, config.WithRegion(region))
+	}
+	if profile != "" {
+		opts = append(opts, config.WithSharedConfigProfile(profile))
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return aws.Config{}, err
+	}
+
+	if cfg.Region == "" {
+		cfg.Region = defaultRegion
+	}
+
+	if cfg.Credentials != nil {
+		cfg.Credentials = &loggedCredentials{CredentialsProvider: cfg.Credentials}
+	}
+
+	if assumeRole != "" {
+		cfg.Credentials, err = assumeRoles(ctx, cfg, prog)
+		if err != nil {
+			return aws.Config{}, err
+		}
+	}
+
+	return cfg, nil
+}
+
+// loggedCredentials logs which provider the credentials came from the first
+// time they are retrieved.
+type loggedCredentials struct {
+	aws.CredentialsProvider
+	once sync.Once
+}
+
+func (c *loggedCredentials) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	creds, err := c.CredentialsProvider.Retrieve(ctx)
+	if err != nil {
+		return creds, err
+	}
+
+	c.once.Do(func() {
+		fields := log.Fields{
+			"provider": credentialsProvider(creds.Source),
+		}
+		if creds.Source == stscreds.WebIdentityProviderName {
+			fields["role_arn"] = os.Getenv("AWS_ROLE_ARN")
+		}
+		log.WithFields(fields).Info("using aws credentials")
+	})
+
+	return creds, nil
+}
+
+// Link-local addresses of the EKS Pod Identity agent.
+var podIdentityHosts = map[string]bool{
+	"169.254.170.23": true,
+	"fd00:ec2::23":   true,
+}
+
+// credentialsProvider describes the provider named by the source of
+// credentials, for logging.
+func credentialsProvider(source string) string {
+	switch {
+	case source == config.CredentialsSourceName:
+		return "environment"
+	case strings.HasPrefix(source, "SharedConfigCredentials"):
+		return "shared credentials file"
+	case source == processcreds.ProviderName:
+		return "credential_process"
+	case source == ssocreds.ProviderName:
+		return "sso"
+	case source == stscreds.ProviderName:
+		return "assume role"
+	case source == stscreds.WebIdentityProviderName:
+		return "web identity (EKS IRSA)"
+	case source == endpointcreds.ProviderName:
+		if u, err := url.Parse(os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")); err == nil && podIdentityHosts[u.Hostname()] {
+			return "container (EKS Pod Identity)"
+		}
+		return "container"
+	case source == ec2rolecreds.ProviderName:
+		return "instance metadata"
+	default:
+		return source
+	}
+}
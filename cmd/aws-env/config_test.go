@@ -0,0 +1,584 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli"
+)
+
+// isolateAWSEnv clears the AWS settings of the environment and the flags
+// that override them, so that credentials only come from what a test sets
+// up. The shared config files are looked up in a new home directory, which
+// is returned.
+func isolateAWSEnv(t *testing.T) string {
+	t.Helper()
+
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		if strings.HasPrefix(name, "AWS_") {
+			t.Setenv(name, "")
+			require.NoError(t, os.Unsetenv(name))
+		}
+	}
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(home, "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(home, "credentials"))
+	// never reach a real metadata service
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+
+	origRegion, origProfile, origAssumeRole := region, profile, assumeRole
+	origExternalID, origMFASerial, origMFAToken, origMFATokenStdin := externalID, mfaSerial, mfaToken, mfaTokenStdin
+	origDuration, origSessionName, origSessionTags, origSourceIdentity := assumeRoleDuration, roleSessionName, sessionTags, sourceIdentity
+	origEndpointURL, origSTSEndpointURL, origUseFIPS, origUseDualStack := endpointURL, stsEndpointURL, useFIPSEndpoint, useDualStackEndpoint
+	t.Cleanup(func() {
+		region, profile, assumeRole = origRegion, origProfile, origAssumeRole
+		externalID, mfaSerial, mfaToken, mfaTokenStdin = origExternalID, origMFASerial, origMFAToken, origMFATokenStdin
+		assumeRoleDuration, roleSessionName, sessionTags, sourceIdentity = origDuration, origSessionName, origSessionTags, origSourceIdentity
+		endpointURL, stsEndpointURL, useFIPSEndpoint, useDualStackEndpoint = origEndpointURL, origSTSEndpointURL, origUseFIPS, origUseDualStack
+	})
+	region, profile, assumeRole = "", "", ""
+	externalID, mfaSerial, mfaToken, mfaTokenStdin = "", "", "", false
+	assumeRoleDuration, roleSessionName, sessionTags, sourceIdentity = 0, "", nil, ""
+	endpointURL, stsEndpointURL, useFIPSEndpoint, useDualStackEndpoint = "", "", false, false
+
+	return home
+}
+
+func writeTestFile(t *testing.T, fileName, content string, perm os.FileMode) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Dir(fileName), 0700))
+	require.NoError(t, ioutil.WriteFile(fileName, []byte(content), perm))
+}
+
+// expiration is the expiry time returned by the credential stand-ins.
+func expiration() time.Time {
+	return time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+}
+
+// callerKeyPattern captures the access key ID a request was signed with.
+var callerKeyPattern = regexp.MustCompile(`Credential=([^/]+)/`)
+
+// stsCall is a request received by fakeSTS.
+type stsCall struct {
+	Action         string
+	RoleARN        string
+	SessionName    string
+	CallerKey      string
+	Token          string
+	ExternalID     string
+	Duration       string
+	SerialNumber   string
+	TokenCode      string
+	SourceIdentity string
+	Tags           map[string]string
+}
+
+// fakeSTS is a stand-in for STS, handling AssumeRole and
+// AssumeRoleWithWebIdentity. The access key ID of the credentials it returns
+// is ASIA followed by the upper-cased role name. They expire at the next
+// time in expires, or in an hour once there are none left.
+type fakeSTS struct {
+	mu      sync.Mutex
+	calls   []stsCall
+	expires []time.Duration
+}
+
+// start starts the stand-in, returning its URL.
+func (f *fakeSTS) start(t *testing.T) string {
+	t.Helper()
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+// install starts the stand-in, and points STS clients at it.
+func (f *fakeSTS) install(t *testing.T) {
+	t.Helper()
+	t.Setenv("AWS_ENDPOINT_URL_STS", f.start(t))
+}
+
+func (f *fakeSTS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	call := stsCall{
+		Action:         r.PostForm.Get("Action"),
+		RoleARN:        r.PostForm.Get("RoleArn"),
+		SessionName:    r.PostForm.Get("RoleSessionName"),
+		Token:          r.PostForm.Get("WebIdentityToken"),
+		ExternalID:     r.PostForm.Get("ExternalId"),
+		Duration:       r.PostForm.Get("DurationSeconds"),
+		SerialNumber:   r.PostForm.Get("SerialNumber"),
+		TokenCode:      r.PostForm.Get("TokenCode"),
+		SourceIdentity: r.PostForm.Get("SourceIdentity"),
+	}
+	for i := 1; r.PostForm.Get(fmt.Sprintf("Tags.member.%d.Key", i)) != ""; i++ {
+		if call.Tags == nil {
+			call.Tags = make(map[string]string)
+		}
+		call.Tags[r.PostForm.Get(fmt.Sprintf("Tags.member.%d.Key", i))] = r.PostForm.Get(fmt.Sprintf("Tags.member.%d.Value", i))
+	}
+	if m := callerKeyPattern.FindStringSubmatch(r.Header.Get("Authorization")); m != nil {
+		call.CallerKey = m[1]
+	}
+
+	f.mu.Lock()
+	f.calls = append(f.calls, call)
+	expires := expiration()
+	if len(f.expires) > 0 {
+		expires = time.Now().Add(f.expires[0]).UTC()
+		f.expires = f.expires[1:]
+	}
+	f.mu.Unlock()
+
+	roleName := call.RoleARN[strings.LastIndex(call.RoleARN, "/")+1:]
+	w.Header().Set("Content-Type", "text/xml")
+	fmt.Fprintf(w, `<%[1]sResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <%[1]sResult>
+    <Credentials>
+      <AccessKeyId>ASIA%[2]s</AccessKeyId>
+      <SecretAccessKey>secret</SecretAccessKey>
+      <SessionToken>token</SessionToken>
+      <Expiration>%[3]s</Expiration>
+    </Credentials>
+    <AssumedRoleUser>
+      <Arn>%[4]s/%[5]s</Arn>
+      <AssumedRoleId>AROA%[2]s:%[5]s</AssumedRoleId>
+    </AssumedRoleUser>
+  </%[1]sResult>
+  <ResponseMetadata><RequestId>request-id</RequestId></ResponseMetadata>
+</%[1]sResponse>`, call.Action, strings.ToUpper(roleName), expires.Format(time.RFC3339), call.RoleARN, call.SessionName)
+}
+
+// Calls returns the requests received so far.
+func (f *fakeSTS) Calls() []stsCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]stsCall(nil), f.calls...)
+}
+
+// credentialsJSON writes credentials as served by the container and
+// instance metadata credential endpoints.
+func credentialsJSON(w http.ResponseWriter, accessKey string) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"Code":            "Success",
+		"AccessKeyId":     accessKey,
+		"SecretAccessKey": "secret",
+		"Token":           "token",
+		"Expiration":      expiration().Format(time.RFC3339),
+	})
+}
+
+// retrieve loads the config as the CLI does, and returns the access key ID
+// and source of the credentials found.
+func retrieve(t *testing.T) (accessKey, source string) {
+	t.Helper()
+	ctx := context.Background()
+
+	cfg, err := loadAWSConfig(ctx, "")
+	require.NoError(t, err)
+
+	creds, err := cfg.Credentials.Retrieve(ctx)
+	require.NoError(t, err)
+	return creds.AccessKeyID, creds.Source
+}
+
+func TestLoadAWSConfig_envCredentials(t *testing.T) {
+	isolateAWSEnv(t)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDENV")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	key, source := retrieve(t)
+	require.Equal(t, "AKIDENV", key)
+	require.Equal(t, "EnvConfigCredentials", source)
+}
+
+func TestLoadAWSConfig_profile(t *testing.T) {
+	home := isolateAWSEnv(t)
+	writeTestFile(t, filepath.Join(home, "credentials"), `
+[default]
+aws_access_key_id = AKIDDEFAULT
+aws_secret_access_key = secret
+
+[local]
+aws_access_key_id = AKIDLOCAL
+aws_secret_access_key = secret
+`, 0600)
+
+	key, _ := retrieve(t)
+	require.Equal(t, "AKIDDEFAULT", key)
+
+	t.Setenv("AWS_PROFILE", "missing")
+	profile = "local"
+	key, _ = retrieve(t)
+	require.Equal(t, "AKIDLOCAL", key)
+}
+
+func TestLoadAWSConfig_credentialProcess(t *testing.T) {
+	home := isolateAWSEnv(t)
+	script := filepath.Join(home, "creds.sh")
+	writeTestFile(t, script, `#!/bin/sh
+echo '{"Version": 1, "AccessKeyId": "AKIDPROCESS", "SecretAccessKey": "secret"}'
+`, 0700)
+	writeTestFile(t, filepath.Join(home, "config"), `
+[profile process]
+credential_process = `+script+`
+`, 0600)
+
+	profile = "process"
+	key, source := retrieve(t)
+	require.Equal(t, "AKIDPROCESS", key)
+	require.Equal(t, "ProcessProvider", source)
+}
+
+func TestLoadAWSConfig_sourceProfile(t *testing.T) {
+	home := isolateAWSEnv(t)
+	sts := &fakeSTS{}
+	sts.install(t)
+	writeTestFile(t, filepath.Join(home, "credentials"), `
+[base]
+aws_access_key_id = AKIDBASE
+aws_secret_access_key = secret
+`, 0600)
+	writeTestFile(t, filepath.Join(home, "config"), `
+[profile app]
+role_arn = arn:aws:iam::111122223333:role/app
+source_profile = base
+`, 0600)
+
+	t.Setenv("AWS_PROFILE", "app")
+	key, source := retrieve(t)
+	require.Equal(t, "ASIAAPP", key)
+	require.Equal(t, "AssumeRoleProvider", source)
+
+	calls := sts.Calls()
+	require.Len(t, calls, 1)
+	require.Equal(t, "AssumeRole", calls[0].Action)
+	require.Equal(t, "arn:aws:iam::111122223333:role/app", calls[0].RoleARN)
+	require.Equal(t, "AKIDBASE", calls[0].CallerKey)
+}
+
+func TestLoadAWSConfig_assumeRole(t *testing.T) {
+	isolateAWSEnv(t)
+	sts := &fakeSTS{}
+	sts.install(t)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDENV")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	assumeRole = "arn:aws:iam::111122223333:role/ssm-reader"
+	key, source := retrieve(t)
+	require.Equal(t, "ASIASSM-READER", key)
+	require.Equal(t, "AssumeRoleProvider", source)
+
+	require.Equal(t, []stsCall{{
+		Action:      "AssumeRole",
+		RoleARN:     "arn:aws:iam::111122223333:role/ssm-reader",
+		SessionName: assumeRoleSessionName,
+		CallerKey:   "AKIDENV",
+		Duration:    "900",
+	}}, sts.Calls())
+}
+
+func TestLoadAWSConfig_assumeRoleError(t *testing.T) {
+	isolateAWSEnv(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `<ErrorResponse><Error><Type>Sender</Type><Code>AccessDenied</Code><Message>denied</Message></Error></ErrorResponse>`)
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("AWS_ENDPOINT_URL_STS", srv.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDENV")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	assumeRole = "arn:aws:iam::111122223333:role/ssm-reader"
+	_, err := loadAWSConfig(context.Background(), "")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "AccessDenied")
+}
+
+func TestLoadAWSConfig_sso(t *testing.T) {
+	home := isolateAWSEnv(t)
+	const startURL = "https://example.awsapps.com/start"
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/federation/credentials" || r.Header.Get("X-Amz-Sso_bearer_token") != "sso-token" {
+			http.NotFound(w, r)
+			return
+		}
+		require.Equal(t, "111122223333", r.URL.Query().Get("account_id"))
+		require.Equal(t, "Developer", r.URL.Query().Get("role_name"))
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"roleCredentials": {"accessKeyId": "ASIASSO", "secretAccessKey": "secret", "sessionToken": "token", "expiration": %d}}`,
+			expiration().UnixMilli())
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("AWS_ENDPOINT_URL_SSO", srv.URL)
+
+	// the token cached by aws sso login
+	sum := sha1.Sum([]byte(startURL))
+	writeTestFile(t, filepath.Join(home, ".aws", "sso", "cache", hex.EncodeToString(sum[:])+".json"),
+		`{"accessToken": "sso-token", "expiresAt": "`+expiration().Format(time.RFC3339)+`"}`, 0600)
+	writeTestFile(t, filepath.Join(home, "config"), `
+[profile dev]
+sso_start_url = `+startURL+`
+sso_region = us-east-1
+sso_account_id = 111122223333
+sso_role_name = Developer
+`, 0600)
+
+	profile = "dev"
+	key, source := retrieve(t)
+	require.Equal(t, "ASIASSO", key)
+	require.Equal(t, "SSOProvider", source)
+}
+
+func TestLoadAWSConfig_containerCredentials(t *testing.T) {
+	isolateAWSEnv(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/creds" || r.Header.Get("Authorization") != "container-token" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		credentialsJSON(w, "ASIACONTAINER")
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", srv.URL+"/creds")
+	t.Setenv("AWS_CONTAINER_AUTHORIZATION_TOKEN", "container-token")
+
+	key, source := retrieve(t)
+	require.Equal(t, "ASIACONTAINER", key)
+	require.Equal(t, "CredentialsEndpointProvider", source)
+}
+
+func TestLoadAWSConfig_instanceMetadata(t *testing.T) {
+	isolateAWSEnv(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/latest/api/token" && r.Method == http.MethodPut {
+			w.Header().Set("X-Aws-Ec2-Metadata-Token-Ttl-Seconds", "21600")
+			fmt.Fprint(w, "imds-token")
+			return
+		}
+		if r.Header.Get("X-Aws-Ec2-Metadata-Token") != "imds-token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/latest/meta-data/iam/security-credentials/":
+			fmt.Fprint(w, "my-role")
+		case "/latest/meta-data/iam/security-credentials/my-role":
+			credentialsJSON(w, "ASIAINSTANCE")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "false")
+	t.Setenv("AWS_EC2_METADATA_SERVICE_ENDPOINT", srv.URL)
+
+	key, source := retrieve(t)
+	require.Equal(t, "ASIAINSTANCE", key)
+	require.Equal(t, "EC2RoleProvider", source)
+}
+
+// captureLog returns a buffer collecting what is logged until the test ends.
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &buf
+}
+
+func TestLoadAWSConfig_webIdentity(t *testing.T) {
+	home := isolateAWSEnv(t)
+	sts := &fakeSTS{}
+	sts.install(t)
+	logs := captureLog(t)
+
+	// as set up by EKS for a service account with an IAM role
+	tokenFile := filepath.Join(home, "token")
+	writeTestFile(t, tokenFile, "irsa-token", 0600)
+	t.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", tokenFile)
+	t.Setenv("AWS_ROLE_ARN", "arn:aws:iam::111122223333:role/pod")
+	t.Setenv("AWS_ROLE_SESSION_NAME", "my-app")
+
+	key, source := retrieve(t)
+	require.Equal(t, "ASIAPOD", key)
+	require.Equal(t, "WebIdentityCredentials", source)
+	require.Equal(t, []stsCall{{
+		Action:      "AssumeRoleWithWebIdentity",
+		RoleARN:     "arn:aws:iam::111122223333:role/pod",
+		SessionName: "my-app",
+		Token:       "irsa-token",
+	}}, sts.Calls())
+
+	require.Contains(t, logs.String(), `provider="web identity (EKS IRSA)"`)
+	require.Contains(t, logs.String(), `role_arn="arn:aws:iam::111122223333:role/pod"`)
+	require.NotContains(t, logs.String(), "irsa-token")
+	require.NotContains(t, logs.String(), "ASIAPOD")
+
+	// --assume-role is assumed with the service account's role
+	assumeRole = "arn:aws:iam::444455556666:role/ssm-reader"
+	key, _ = retrieve(t)
+	require.Equal(t, "ASIASSM-READER", key)
+
+	calls := sts.Calls()
+	require.Len(t, calls, 3)
+	require.Equal(t, "AssumeRole", calls[2].Action)
+	require.Equal(t, "ASIAPOD", calls[2].CallerKey)
+}
+
+func TestLoadAWSConfig_podIdentity(t *testing.T) {
+	home := isolateAWSEnv(t)
+	sts := &fakeSTS{}
+	sts.install(t)
+	logs := captureLog(t)
+
+	// the Pod Identity agent listens on 169.254.170.23, which the SDK
+	// allows along with loopback addresses
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/credentials" || r.Header.Get("Authorization") != "pod-identity-token" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		credentialsJSON(w, "ASIAPODIDENTITY")
+	}))
+	t.Cleanup(srv.Close)
+
+	tokenFile := filepath.Join(home, "eks-pod-identity-token")
+	writeTestFile(t, tokenFile, "pod-identity-token", 0600)
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", srv.URL+"/v1/credentials")
+	t.Setenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE", tokenFile)
+
+	assumeRole = "arn:aws:iam::444455556666:role/ssm-reader"
+	key, _ := retrieve(t)
+	require.Equal(t, "ASIASSM-READER", key)
+	require.Equal(t, []stsCall{{
+		Action:      "AssumeRole",
+		RoleARN:     "arn:aws:iam::444455556666:role/ssm-reader",
+		SessionName: assumeRoleSessionName,
+		CallerKey:   "ASIAPODIDENTITY",
+		Duration:    "900",
+	}}, sts.Calls())
+
+	require.Contains(t, logs.String(), `provider=container`)
+	require.Contains(t, logs.String(), `assume_role="arn:aws:iam::444455556666:role/ssm-reader"`)
+	require.NotContains(t, logs.String(), "pod-identity-token")
+}
+
+func TestCredentialsProvider(t *testing.T) {
+	tests := []struct {
+		source string
+		uri    string
+		want   string
+	}{
+		{source: "EnvConfigCredentials", want: "environment"},
+		{source: "SharedConfigCredentials: /home/app/.aws/credentials", want: "shared credentials file"},
+		{source: "WebIdentityCredentials", want: "web identity (EKS IRSA)"},
+		{source: "CredentialsEndpointProvider", want: "container"},
+		{source: "CredentialsEndpointProvider", uri: "http://169.254.170.23/v1/credentials", want: "container (EKS Pod Identity)"},
+		{source: "CredentialsEndpointProvider", uri: "http://[fd00:ec2::23]/v1/credentials", want: "container (EKS Pod Identity)"},
+		{source: "EC2RoleProvider", want: "instance metadata"},
+		{source: "CustomProvider", want: "CustomProvider"},
+	}
+
+	for _, test := range tests {
+		t.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", test.uri)
+		require.Equal(t, test.want, credentialsProvider(test.source), test.source)
+	}
+}
+
+// regionFlagDefault returns the default value of the --region flag.
+func regionFlagDefault(t *testing.T) string {
+	t.Helper()
+	for _, flag := range app.Flags {
+		if f, ok := flag.(cli.StringFlag); ok && f.Name == "region" {
+			return f.Value
+		}
+	}
+	t.Fatal("no --region flag")
+	return ""
+}
+
+func TestLoadAWSConfig_region(t *testing.T) {
+	tests := []struct {
+		name    string
+		flag    string
+		env     string
+		profile string
+		want    string
+	}{
+		{
+			name: "empty_flag",
+			want: defaultRegion,
+		},
+		{
+			name:    "profile",
+			profile: "eu-west-1",
+			want:    "eu-west-1",
+		},
+		{
+			name:    "env",
+			env:     "us-west-2",
+			profile: "eu-west-1",
+			want:    "us-west-2",
+		},
+		{
+			name:    "flag",
+			flag:    "ap-southeast-2",
+			env:     "us-west-2",
+			profile: "eu-west-1",
+			want:    "ap-southeast-2",
+		},
+		{
+			// the flag defaults to us-east-1, as it always has
+			name:    "flag_default",
+			flag:    regionFlagDefault(t),
+			env:     "us-west-2",
+			profile: "eu-west-1",
+			want:    "us-east-1",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			home := isolateAWSEnv(t)
+			if test.profile != "" {
+				writeTestFile(t, filepath.Join(home, "config"), "[default]\nregion = "+test.profile+"\n", 0600)
+			}
+			if test.env != "" {
+				t.Setenv("AWS_REGION", test.env)
+			}
+			region = test.flag
+
+			cfg, err := loadAWSConfig(context.Background(), "")
+			require.NoError(t, err)
+			require.Equal(t, test.want, cfg.Region)
+		})
+	}
+}
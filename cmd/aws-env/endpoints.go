@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// endpointOptions returns the config options selecting endpoints, as set by
+// the --sts-endpoint-url, --use-fips-endpoint and --use-dualstack-endpoint
+// flags. --endpoint-url is applied by withSSMEndpoint and
+// withSecretsManagerEndpoint instead.
+func endpointOptions() ([]func(*config.LoadOptions) error, error) {
+	for _, endpoint := range []struct{ flag, url string }{
+		{"--endpoint-url", endpointURL},
+		{"--sts-endpoint-url", stsEndpointURL},
+	} {
+		if endpoint.url == "" {
+			continue
+		}
+		if u, err := url.Parse(endpoint.url); err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid %s %q, must be a URL such as http://localhost:4566", endpoint.flag, endpoint.url)
+		}
+	}
+
+	// the SDK rejects FIPS and dual-stack with custom endpoints
+	if (useFIPSEndpoint || useDualStackEndpoint) && (endpointURL != "" || stsEndpointURL != "") {
+		return nil, errors.New("--use-fips-endpoint and --use-dualstack-endpoint cannot be used with --endpoint-url or --sts-endpoint-url")
+	}
+
+	var opts []func(*config.LoadOptions) error
+	if useFIPSEndpoint {
+		opts = append(opts, config.WithUseFIPSEndpoint(aws.FIPSEndpointStateEnabled))
+	}
+	if useDualStackEndpoint {
+		opts = append(opts, config.WithUseDualStackEndpoint(aws.DualStackEndpointStateEnabled))
+	}
+	if stsEndpointURL != "" {
+		// roles assumed by the SDK itself, for source_profile and web
+		// identity tokens, use STS clients it creates, so those clients are
+		// wrapped to change the endpoint of their calls
+		opts = append(opts,
+			config.WithAssumeRoleCredentialOptions(func(o *stscreds.AssumeRoleOptions) {
+				if o.Client != nil {
+					o.Client = assumeRoleEndpointClient{o.Client}
+				}
+			}),
+			config.WithWebIdentityRoleCredentialOptions(func(o *stscreds.WebIdentityRoleOptions) {
+				if o.Client != nil {
+					o.Client = webIdentityEndpointClient{o.Client}
+				}
+			}),
+		)
+	}
+
+	return opts, nil
+}
+
+// withSSMEndpoint sends the calls of a Parameter Store client to the
+// --endpoint-url endpoint, if set.
+func withSSMEndpoint(o *ssm.Options) {
+	if endpointURL != "" {
+		o.BaseEndpoint = aws.String(endpointURL)
+	}
+}
+
+// withSecretsManagerEndpoint sends the calls of a Secrets Manager client to
+// the --endpoint-url endpoint, if set.
+func withSecretsManagerEndpoint(o *secretsmanager.Options) {
+	if endpointURL != "" {
+		o.BaseEndpoint = aws.String(endpointURL)
+	}
+}
+
+// stsOptions returns the options of the STS clients assuming the roles of
+// --assume-role and --account-role, which send their calls to the
+// --sts-endpoint-url endpoint, if set.
+func stsOptions() []func(*sts.Options) {
+	if stsEndpointURL == "" {
+		return nil
+	}
+	return []func(*sts.Options){stsEndpoint}
+}
+
+// stsEndpoint sets the --sts-endpoint-url endpoint on an STS client or call.
+func stsEndpoint(o *sts.Options) {
+	o.BaseEndpoint = aws.String(stsEndpointURL)
+}
+
+// assumeRoleEndpointClient sends AssumeRole calls to the --sts-endpoint-url
+// endpoint. The SDK also applies its options once before creating the
+// client, to validate them, so a nil client is left as it is.
+type assumeRoleEndpointClient struct {
+	stscreds.AssumeRoleAPIClient
+}
+
+func (c assumeRoleEndpointClient) AssumeRole(ctx context.Context, params *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+	return c.AssumeRoleAPIClient.AssumeRole(ctx, params, append(optFns, stsEndpoint)...)
+}
+
+// webIdentityEndpointClient sends AssumeRoleWithWebIdentity calls to the
+// --sts-endpoint-url endpoint. As with assumeRoleEndpointClient, a nil client
+// is left as it is.
+type webIdentityEndpointClient struct {
+	stscreds.AssumeRoleWithWebIdentityAPIClient
+}
+
+func (c webIdentityEndpointClient) AssumeRoleWithWebIdentity(ctx context.Context, params *sts.AssumeRoleWithWebIdentityInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleWithWebIdentityOutput, error) {
+	return c.AssumeRoleWithWebIdentityAPIClient.AssumeRoleWithWebIdentity(ctx, params, append(optFns, stsEndpoint)...)
+}
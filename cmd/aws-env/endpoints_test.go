@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSSM is a stand-in for Parameter Store, as a local emulator would be.
+// It also serves params as secrets of Secrets Manager, at the same endpoint.
+// It records the access key ID each request was signed with.
+func fakeSSM(t *testing.T, params map[string]string, callerKeys *[]string) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target := r.Header.Get("X-Amz-Target")
+		if target != "AmazonSSM.GetParameters" && target != "secretsmanager.BatchGetSecretValue" {
+			http.Error(w, "unexpected call", http.StatusBadRequest)
+			return
+		}
+		if m := callerKeyPattern.FindStringSubmatch(r.Header.Get("Authorization")); m != nil {
+			*callerKeys = append(*callerKeys, m[1])
+		}
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+
+		if target == "secretsmanager.BatchGetSecretValue" {
+			var input struct{ SecretIdList []string }
+			if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			type secretValue struct{ Name, SecretString string }
+			type apiError struct{ SecretId, ErrorCode string }
+			var output struct {
+				SecretValues []secretValue
+				Errors       []apiError
+			}
+			for _, id := range input.SecretIdList {
+				if val, ok := params[id]; ok {
+					output.SecretValues = append(output.SecretValues, secretValue{id, val})
+					continue
+				}
+				output.Errors = append(output.Errors, apiError{id, "ResourceNotFoundException"})
+			}
+			_ = json.NewEncoder(w).Encode(output)
+			return
+		}
+
+		var input struct{ Names []string }
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		type parameter struct{ Name, Value string }
+		output := struct {
+			Parameters        []parameter
+			InvalidParameters []string
+		}{InvalidParameters: []string{}}
+		for _, name := range input.Names {
+			if val, ok := params[name]; ok {
+				output.Parameters = append(output.Parameters, parameter{name, val})
+				continue
+			}
+			output.InvalidParameters = append(output.InvalidParameters, name)
+		}
+
+		_ = json.NewEncoder(w).Encode(output)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func TestEndpointURL(t *testing.T) {
+	home := isolateAWSEnv(t)
+	origSource := source
+	t.Cleanup(func() { source = origSource })
+	source = sourceSSM
+
+	sts := &fakeSTS{}
+	stsEndpointURL = sts.start(t)
+	var callerKeys []string
+	endpointURL = fakeSSM(t, map[string]string{"/app/key": "from-emulator"}, &callerKeys)
+
+	writeTestFile(t, filepath.Join(home, "credentials"), `
+[base]
+aws_access_key_id = AKIDBASE
+aws_secret_access_key = secret
+`, 0600)
+	writeTestFile(t, filepath.Join(home, "config"), `
+[profile app]
+role_arn = arn:aws:iam::111122223333:role/app
+source_profile = base
+`, 0600)
+	profile = "app"
+	assumeRole = "arn:aws:iam::111122223333:role/ssm-reader"
+
+	ctx := context.Background()
+	cfg, err := loadAWSConfig(ctx, "my-app")
+	require.NoError(t, err)
+
+	getter, err := newParamsGetter(cfg)
+	require.NoError(t, err)
+	got, err := getter.GetParams(ctx, []string{"/app/key"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"/app/key": "from-emulator"}, got)
+
+	// both the profile's role and --assume-role are assumed through
+	// --sts-endpoint-url
+	calls := sts.Calls()
+	require.Len(t, calls, 2)
+	require.Equal(t, "arn:aws:iam::111122223333:role/app", calls[0].RoleARN)
+	require.Equal(t, "AKIDBASE", calls[0].CallerKey)
+	require.Equal(t, "arn:aws:iam::111122223333:role/ssm-reader", calls[1].RoleARN)
+	require.Equal(t, "ASIAAPP", calls[1].CallerKey)
+	require.Equal(t, []string{"ASIASSM-READER"}, callerKeys)
+}
+
+func TestEndpointURL_secretsManager(t *testing.T) {
+	isolateAWSEnv(t)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDENV")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	origSource := source
+	t.Cleanup(func() { source = origSource })
+	source = sourceSSM
+
+	var callerKeys []string
+	endpointURL = fakeSSM(t, map[string]string{"/app/key": "from-ssm", "app/db": "from-secrets-manager"}, &callerKeys)
+
+	ctx := context.Background()
+	cfg, err := loadAWSConfig(ctx, "")
+	require.NoError(t, err)
+
+	getter, err := newParamsGetter(cfg)
+	require.NoError(t, err)
+	got, err := getter.GetParams(ctx, []string{"/app/key", "sm:app/db"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"/app/key": "from-ssm", "sm:app/db": "from-secrets-manager"}, got)
+
+	// both services are called at --endpoint-url
+	require.Equal(t, []string{"AKIDENV", "AKIDENV"}, callerKeys)
+}
+
+func TestEndpointURL_webIdentity(t *testing.T) {
+	home := isolateAWSEnv(t)
+	sts := &fakeSTS{}
+	stsEndpointURL = sts.start(t)
+
+	tokenFile := filepath.Join(home, "token")
+	writeTestFile(t, tokenFile, "irsa-token", 0600)
+	t.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", tokenFile)
+	t.Setenv("AWS_ROLE_ARN", "arn:aws:iam::111122223333:role/pod")
+
+	key, _ := retrieve(t)
+	require.Equal(t, "ASIAPOD", key)
+
+	calls := sts.Calls()
+	require.Len(t, calls, 1)
+	require.Equal(t, "AssumeRoleWithWebIdentity", calls[0].Action)
+}
+
+// hostRecorder is an HTTP client recording the host of each request, which
+// it fails.
+type hostRecorder []string
+
+func (h *hostRecorder) Do(r *http.Request) (*http.Response, error) {
+	*h = append(*h, r.URL.Host)
+	return nil, errors.New("not sent")
+}
+
+func TestEndpointOptions(t *testing.T) {
+	tests := []struct {
+		name      string
+		fips      bool
+		dualStack bool
+		want      string
+	}{
+		{
+			name: "default",
+			want: "ssm.us-west-2.amazonaws.com",
+		},
+		{
+			name: "fips",
+			fips: true,
+			want: "ssm-fips.us-west-2.amazonaws.com",
+		},
+		{
+			name:      "dual_stack",
+			dualStack: true,
+			want:      "ssm.us-west-2.api.aws",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			isolateAWSEnv(t)
+			t.Setenv("AWS_ACCESS_KEY_ID", "AKIDENV")
+			t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+			region = "us-west-2"
+			useFIPSEndpoint, useDualStackEndpoint = test.fips, test.dualStack
+
+			cfg, err := loadAWSConfig(context.Background(), "")
+			require.NoError(t, err)
+
+			var hosts hostRecorder
+			client := ssm.NewFromConfig(cfg, withSSMEndpoint, func(o *ssm.Options) {
+				o.HTTPClient = &hosts
+				o.Retryer = aws.NopRetryer{}
+			})
+			_, err = client.GetParameters(context.Background(), &ssm.GetParametersInput{Names: []string{"/app/key"}})
+			require.Error(t, err)
+			require.Equal(t, hostRecorder{test.want}, hosts)
+		})
+	}
+}
+
+func TestEndpointOptions_invalid(t *testing.T) {
+	tests := []struct {
+		name      string
+		endpoint  string
+		sts       string
+		fips      bool
+		dualStack bool
+		wantErr   string
+	}{
+		{
+			name:     "endpoint_not_url",
+			endpoint: "localhost:4566",
+			wantErr:  `invalid --endpoint-url "localhost:4566", must be a URL such as http://localhost:4566`,
+		},
+		{
+			name:    "sts_endpoint_not_url",
+			sts:     "sts.internal",
+			wantErr: `invalid --sts-endpoint-url "sts.internal", must be a URL such as http://localhost:4566`,
+		},
+		{
+			name:     "fips_with_endpoint",
+			endpoint: "http://localhost:4566",
+			fips:     true,
+			wantErr:  "--use-fips-endpoint and --use-dualstack-endpoint cannot be used with --endpoint-url or --sts-endpoint-url",
+		},
+		{
+			name:      "dual_stack_with_sts_endpoint",
+			sts:       "http://localhost:4566",
+			dualStack: true,
+			wantErr:   "--use-fips-endpoint and --use-dualstack-endpoint cannot be used with --endpoint-url or --sts-endpoint-url",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			isolateAWSEnv(t)
+			endpointURL, stsEndpointURL = test.endpoint, test.sts
+			useFIPSEndpoint, useDualStackEndpoint = test.fips, test.dualStack
+
+			_, err := loadAWSConfig(context.Background(), "")
+			require.EqualError(t, err, test.wantErr)
+		})
+	}
+}
+
+func TestNewRegionalGetter_endpointURL(t *testing.T) {
+	isolateAWSEnv(t)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDENV")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	var callerKeys []string
+	endpointURL = fakeSSM(t, map[string]string{"/app/key": "from-emulator"}, &callerKeys)
+
+	ctx := context.Background()
+	cfg, err := loadAWSConfig(ctx, "")
+	require.NoError(t, err)
+	factory := newRegionalGetter(cfg, nil)
+
+	// the parameter ARN is in the region of --endpoint-url
+	getter, err := factory("aws", cfg.Region, "")
+	require.NoError(t, err)
+	got, err := getter.GetParams(ctx, []string{"/app/key"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"/app/key": "from-emulator"}, got)
+	require.Equal(t, []string{"AKIDENV"}, callerKeys)
+
+	_, err = factory("aws-cn", "us-west-2", "")
+	require.EqualError(t, err, "region us-west-2 is in partition aws, not aws-cn")
+}
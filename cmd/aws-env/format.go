@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// formatter writes env vars in a form understood by a shell or tool.
+type formatter func(w io.Writer, vars map[string]string) error
+
+// Supported values of the --format flag.
+var formats = map[string]formatter{
+	"bash":       lineFormatter(func(name, val string) string { return "export " + name + "=" + quoteANSIC(val) }),
+	"zsh":        lineFormatter(func(name, val string) string { return "export " + name + "=" + quoteANSIC(val) }),
+	"sh":         lineFormatter(func(name, val string) string { return "export " + name + "=" + quotePOSIX(val) }),
+	"fish":       lineFormatter(func(name, val string) string { return "set -gx " + name + " " + quoteFish(val) }),
+	"powershell": lineFormatter(func(name, val string) string { return "$env:" + name + " = " + quotePowerShell(val) }),
+	"dotenv":     lineFormatter(func(name, val string) string { return name + "=" + quoteDotenv(val) }),
+	"docker":     formatDocker,
+	"systemd":    lineFormatter(func(name, val string) string { return name + "=" + quoteSystemd(val) }),
+	"json":       formatJSON,
+	"yaml":       formatYAML,
+}
+
+// formatNames returns the supported values of the --format flag.
+func formatNames() string {
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// validName matches names that every format can represent.
+var validName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// checkVars returns an error if any name or value cannot be exported.
+// Values cannot contain NUL bytes, as no environment can hold them.
+func checkVars(vars map[string]string) error {
+	for _, name := range sortedNames(vars) {
+		val := vars[name]
+		if !validName.MatchString(name) {
+			return fmt.Errorf("cannot export %q: invalid name", name)
+		}
+		if strings.IndexByte(val, 0) >= 0 {
+			return fmt.Errorf("cannot export %s: value contains a NUL byte", name)
+		}
+	}
+	return nil
+}
+
+// sortedNames returns the names of vars in sorted order.
+func sortedNames(vars map[string]string) []string {
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lineFormatter returns a formatter writing one line per var, as built by
+// line.
+func lineFormatter(line func(name, val string) string) formatter {
+	return func(w io.Writer, vars map[string]string) error {
+		if err := checkVars(vars); err != nil {
+			return err
+		}
+
+		for _, name := range sortedNames(vars) {
+			if _, err := fmt.Fprintln(w, line(name, vars[name])); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// quoteANSIC quotes s as a bash or zsh $'...' string, in which every
+// character but \ and ' is literal, and control characters are escaped.
+func quoteANSIC(s string) string {
+	var b strings.Builder
+	b.WriteString("$'")
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\', '\'':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if c < 0x20 || c == 0x7f {
+				fmt.Fprintf(&b, `\x%02x`, c)
+				continue
+			}
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('\'')
+	return b.String()
+}
+
+// quotePOSIX quotes s as a POSIX shell single-quoted string, in which every
+// character is literal. A single quote is written by closing the string,
+// adding an escaped quote and opening it again.
+func quotePOSIX(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// quoteFish quotes s as a fish single-quoted string, in which only \ and '
+// are escaped.
+func quoteFish(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+// quotePowerShell quotes s as a PowerShell single-quoted string, in which
+// every character is literal and quotes are doubled. PowerShell also treats
+// the typographic single quotes as quotes.
+func quotePowerShell(s string) string {
+	return "'" + strings.NewReplacer(
+		"'", "''",
+		"‘", "‘‘",
+		"’", "’’",
+		"‚", "‚‚",
+		"‛", "‛‛",
+	).Replace(s) + "'"
+}
+
+// quoteDotenv quotes s as a double-quoted dotenv value on a single line.
+// $ is escaped so that it is not expanded by loaders that support variable
+// references.
+func quoteDotenv(s string) string {
+	return `"` + strings.NewReplacer(
+		`\`, `\\`,
+		`"`, `\"`,
+		`$`, `\$`,
+		"\n", `\n`,
+		"\r", `\r`,
+		"\t", `\t`,
+	).Replace(s) + `"`
+}
+
+// quoteSystemd quotes s as a double-quoted value in a systemd
+// EnvironmentFile, which may span lines. Only \, ", $ and ` are escaped.
+func quoteSystemd(s string) string {
+	return `"` + strings.NewReplacer(
+		`\`, `\\`,
+		`"`, `\"`,
+		`$`, `\$`,
+		"`", "\\`",
+	).Replace(s) + `"`
+}
+
+// formatYAML writes vars as a YAML mapping.
+func formatYAML(w io.Writer, vars map[string]string) error {
+	if err := checkUTF8(vars); err != nil {
+		return err
+	}
+	return lineFormatter(func(name, val string) string { return name + ": " + quoteYAML(val) })(w, vars)
+}
+
+// formatDocker writes vars for docker run --env-file, which takes each
+// value literally up to the end of the line, so values cannot contain
+// newlines.
+func formatDocker(w io.Writer, vars map[string]string) error {
+	if err := checkVars(vars); err != nil {
+		return err
+	}
+
+	for _, name := range sortedNames(vars) {
+		val := vars[name]
+		if strings.ContainsAny(val, "\r\n") {
+			return fmt.Errorf("cannot export %s: docker env files cannot hold values with newlines", name)
+		}
+		if _, err := fmt.Fprintf(w, "%s=%s\n", name, val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formatJSON writes vars as a JSON object.
+func formatJSON(w io.Writer, vars map[string]string) error {
+	if err := checkVars(vars); err != nil {
+		return err
+	}
+	if err := checkUTF8(vars); err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(vars)
+}
+
+// quoteYAML quotes s as a YAML double-quoted scalar, so that no value is
+// read as a number, boolean or null. Characters YAML does not allow
+// unescaped are written as \u escapes.
+func quoteYAML(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '"':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r < 0x20, r >= 0x7f && r <= 0x9f, r == 0x2028, r == 0x2029, r == 0xfeff:
+			fmt.Fprintf(&b, `\u%04x`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// checkUTF8 returns an error if any value is not valid UTF-8, which JSON and
+// YAML cannot hold.
+func checkUTF8(vars map[string]string) error {
+	for _, name := range sortedNames(vars) {
+		if !utf8.ValidString(vars[name]) {
+			return fmt.Errorf("cannot export %s: value is not valid UTF-8", name)
+		}
+	}
+	return nil
+}
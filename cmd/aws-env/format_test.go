@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// awkwardVars hold values that are easily mangled by careless quoting.
+var awkwardVars = map[string]string{
+	"QUOTES":    `it's "quoted"`,
+	"NEWLINE":   "line1\nline2",
+	"DOLLAR":    "$HOME ${PATH} `id` $(id)",
+	"BACKSLASH": `C:\path\n\`,
+	"EMPTY":     "",
+}
+
+func TestFormats(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{
+			format: "bash",
+			want: `export BACKSLASH=$'C:\\path\\n\\'
+export DOLLAR=$'$HOME ${PATH} ` + "`id`" + ` $(id)'
+export EMPTY=$''
+export NEWLINE=$'line1\nline2'
+export QUOTES=$'it\'s "quoted"'
+`,
+		},
+		{
+			format: "sh",
+			want: `export BACKSLASH='C:\path\n\'
+export DOLLAR='$HOME ${PATH} ` + "`id`" + ` $(id)'
+export EMPTY=''
+export NEWLINE='line1
+line2'
+export QUOTES='it'\''s "quoted"'
+`,
+		},
+		{
+			format: "fish",
+			want: `set -gx BACKSLASH 'C:\\path\\n\\'
+set -gx DOLLAR '$HOME ${PATH} ` + "`id`" + ` $(id)'
+set -gx EMPTY ''
+set -gx NEWLINE 'line1
+line2'
+set -gx QUOTES 'it\'s "quoted"'
+`,
+		},
+		{
+			format: "powershell",
+			want: `$env:BACKSLASH = 'C:\path\n\'
+$env:DOLLAR = '$HOME ${PATH} ` + "`id`" + ` $(id)'
+$env:EMPTY = ''
+$env:NEWLINE = 'line1
+line2'
+$env:QUOTES = 'it''s "quoted"'
+`,
+		},
+		{
+			format: "dotenv",
+			want: `BACKSLASH="C:\\path\\n\\"
+DOLLAR="\$HOME \${PATH} ` + "`id`" + ` \$(id)"
+EMPTY=""
+NEWLINE="line1\nline2"
+QUOTES="it's \"quoted\""
+`,
+		},
+		{
+			format: "systemd",
+			want: `BACKSLASH="C:\\path\\n\\"
+DOLLAR="\$HOME \${PATH} ` + "\\`id\\`" + ` \$(id)"
+EMPTY=""
+NEWLINE="line1
+line2"
+QUOTES="it's \"quoted\""
+`,
+		},
+		{
+			format: "yaml",
+			want: `BACKSLASH: "C:\\path\\n\\"
+DOLLAR: "$HOME ${PATH} ` + "`id`" + ` $(id)"
+EMPTY: ""
+NEWLINE: "line1\nline2"
+QUOTES: "it's \"quoted\""
+`,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.format, func(t *testing.T) {
+			t.Parallel()
+			var buf bytes.Buffer
+			err := formats[test.format](&buf, awkwardVars)
+			require.NoError(t, err)
+			require.Equal(t, test.want, buf.String())
+		})
+	}
+}
+
+func TestFormats_controlCharacters(t *testing.T) {
+	t.Parallel()
+	vars := map[string]string{"CTRL": "a\tb\rc\x01\x7f\u0085\u2028"}
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{format: "bash", want: "export CTRL=$'a\\tb\\rc\\x01\\x7f\u0085\u2028'\n"},
+		{format: "dotenv", want: "CTRL=\"a\\tb\\rc\x01\x7f\u0085\u2028\"\n"},
+		{format: "yaml", want: `CTRL: "a\tb\rc\u0001\u007f\u0085\u2028"` + "\n"},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.format, func(t *testing.T) {
+			t.Parallel()
+			var buf bytes.Buffer
+			err := formats[test.format](&buf, vars)
+			require.NoError(t, err)
+			require.Equal(t, test.want, buf.String())
+		})
+	}
+}
+
+func TestFormats_PowerShellTypographicQuotes(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	err := formats["powershell"](&buf, map[string]string{"Q": "‘a’ ‚b‛"})
+	require.NoError(t, err)
+	require.Equal(t, "$env:Q = '‘‘a’’ ‚‚b‛‛'\n", buf.String())
+}
+
+func TestFormats_JSON(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	err := formats["json"](&buf, awkwardVars)
+	require.NoError(t, err)
+
+	var got map[string]string
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &got))
+	require.Equal(t, awkwardVars, got)
+}
+
+func TestFormats_Docker(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	err := formats["docker"](&buf, map[string]string{
+		"QUOTES": `it's "quoted"`,
+		"DOLLAR": "$HOME",
+		"SPACES": " a b ",
+	})
+	require.NoError(t, err)
+	require.Equal(t, "DOLLAR=$HOME\nQUOTES=it's \"quoted\"\nSPACES= a b \n", buf.String())
+
+	err = formats["docker"](&buf, map[string]string{"NEWLINE": "line1\nline2"})
+	require.EqualError(t, err, "cannot export NEWLINE: docker env files cannot hold values with newlines")
+}
+
+func TestFormats_invalid(t *testing.T) {
+	t.Parallel()
+	for format := range formats {
+		format := format
+		t.Run(format, func(t *testing.T) {
+			t.Parallel()
+			var buf bytes.Buffer
+			err := formats[format](&buf, map[string]string{"NUL": "a\x00b"})
+			require.EqualError(t, err, "cannot export NUL: value contains a NUL byte")
+
+			err = formats[format](&buf, map[string]string{"BAD-NAME": "x"})
+			require.EqualError(t, err, `cannot export "BAD-NAME": invalid name`)
+			require.Empty(t, buf.String())
+		})
+	}
+
+	var buf bytes.Buffer
+	for _, format := range []string{"json", "yaml"} {
+		err := formats[format](&buf, map[string]string{"BINARY": "\xff\xfe"})
+		require.EqualError(t, err, "cannot export BINARY: value is not valid UTF-8")
+	}
+}
+
+// TestFormats_shells evaluates the output with the shells available, and
+// checks every value comes back unchanged.
+func TestFormats_shells(t *testing.T) {
+	t.Parallel()
+	vars := make(map[string]string, len(awkwardVars)+1)
+	for name, val := range awkwardVars {
+		vars[name] = val
+	}
+	vars["CTRL"] = "a\tb\rc\x01\x7f\n"
+
+	tests := []struct {
+		format string
+		shell  string
+	}{
+		{format: "bash", shell: "bash"},
+		{format: "zsh", shell: "zsh"},
+		{format: "sh", shell: "sh"},
+		{format: "sh", shell: "dash"},
+		{format: "sh", shell: "bash"},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.format+"_"+test.shell, func(t *testing.T) {
+			t.Parallel()
+			shell, err := exec.LookPath(test.shell)
+			if err != nil {
+				t.Skipf("%s not available", test.shell)
+			}
+
+			var buf bytes.Buffer
+			require.NoError(t, formats[test.format](&buf, vars))
+
+			script := filepath.Join(t.TempDir(), "env")
+			require.NoError(t, ioutil.WriteFile(script, buf.Bytes(), 0600))
+
+			for name, want := range vars {
+				// printf keeps trailing newlines, which command substitution would drop
+				out, err := exec.Command(shell, "-c", `. "$1" && printf '%s' "$`+name+`"`, "sh", script).Output() //nolint: gosec
+				require.NoError(t, err)
+				require.Equal(t, want, string(out), "%s in %s", name, test.shell)
+			}
+		})
+	}
+}
@@ -2,25 +2,23 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/sendgrid/aws-env/awsenv"
-	v1 "github.com/sendgrid/aws-env/awsenv/v1"
+	v2 "github.com/sendgrid/aws-env/awsenv/v2"
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
-	"github.com/aws/aws-sdk-go/aws/defaults"
-	"github.com/aws/aws-sdk-go/aws/ec2metadata"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/ssm"
-	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
 )
 
 var (
@@ -36,6 +34,72 @@ var (
 	assumeRole string
 	fileName   string
 	ecs        bool
+	source     string
+
+	expandPrefix   string
+	expandOverride bool
+
+	importPath string
+	recursive  bool
+	pathNaming string
+
+	accountRoles cli.StringSlice
+
+	maxDepth int
+
+	secretFiles    string
+	secretFilesDir string
+
+	outputFormat string
+	fullEnv      bool
+
+	configFile string
+
+	placeholderVars cli.StringSlice
+
+	basePath    string
+	searchPaths string
+
+	externalID         string
+	mfaSerial          string
+	mfaToken           string
+	mfaTokenStdin      bool
+	assumeRoleDuration time.Duration
+	roleSessionName    string
+	sessionTags        cli.StringSlice
+	sourceIdentity     string
+
+	endpointURL          string
+	stsEndpointURL       string
+	useFIPSEndpoint      bool
+	useDualStackEndpoint bool
+
+	verbose bool
+)
+
+// Supported values of the --source flag.
+const (
+	sourceSSM            = "ssm"
+	sourceSecretsManager = "secretsmanager"
+)
+
+// Supported values of the --secret-files flag.
+const (
+	secretFilesSuffix  = "suffix"
+	secretFilesReplace = "replace"
+)
+
+// Supported values of the --path-naming flag.
+var pathNamings = map[string]awsenv.NameMapper{
+	"last":     awsenv.LastSegmentName,
+	"relative": awsenv.RelativePathName,
+}
+
+// Reference schemes that select a backend regardless of the --source flag,
+// e.g. awsenv:ssm:/path/to/param or awsenv:sm:prod/secret.
+const (
+	schemeSSM            = "ssm"
+	schemeSecretsManager = "sm"
 )
 
 const description = `
@@ -45,6 +109,9 @@ variables set from parameter store. If no command is passed, aws-env will
 output export statements suitable for use with eval or source shell builtins.
 It does support a -f flag to do an in place replacement of the first occurrence
 per line of each prefixed item, delimited by whitespace (or \" then whitespace)
+
+References may also be embedded anywhere in a value or line as ${awsenv:...};
+write $${awsenv: for the literal text.
 `
 
 func initApp() *cli.App {
@@ -68,20 +135,122 @@ func initApp() *cli.App {
 			EnvVar:      "AWS_ENV_REGION",
 			Usage:       "aws region parameter store is in",
 			Destination: &region,
-			Value:       "us-east-1",
+			Value:       defaultRegion,
 		},
 		cli.StringFlag{
 			Name:        "profile",
 			EnvVar:      "AWS_ENV_PROFILE",
-			Usage:       "aws profile to use for auth",
+			Usage:       "aws profile to use for auth, overriding AWS_PROFILE",
 			Destination: &profile,
 		},
 		cli.StringFlag{
 			Name:        "assume-role",
 			EnvVar:      "AWS_ENV_ASSUME_ROLE",
-			Usage:       "aws role to assume after initial creds",
+			Usage:       "aws role to assume after initial creds, or a comma-separated chain of roles assumed in order",
 			Destination: &assumeRole,
 		},
+		cli.StringFlag{
+			Name:        "role-session-name",
+			EnvVar:      "AWS_ENV_ROLE_SESSION_NAME",
+			Usage:       "with --assume-role, the role session name (default: aws-env-<command>)",
+			Destination: &roleSessionName,
+		},
+		cli.DurationFlag{
+			Name:        "assume-role-duration",
+			EnvVar:      "AWS_ENV_ASSUME_ROLE_DURATION",
+			Usage:       "with --assume-role, how long the role session lasts (default 15m)",
+			Destination: &assumeRoleDuration,
+		},
+		cli.StringFlag{
+			Name:        "external-id",
+			EnvVar:      "AWS_ENV_EXTERNAL_ID",
+			Usage:       "with --assume-role, the external ID required by the roles",
+			Destination: &externalID,
+		},
+		cli.StringSliceFlag{
+			Name:   "session-tag",
+			EnvVar: "AWS_ENV_SESSION_TAGS",
+			Usage:  "with --assume-role, a session tag as KEY=VALUE (may be repeated)",
+			Value:  &sessionTags,
+		},
+		cli.StringFlag{
+			Name:        "source-identity",
+			EnvVar:      "AWS_ENV_SOURCE_IDENTITY",
+			Usage:       "with --assume-role, the source identity of the role sessions",
+			Destination: &sourceIdentity,
+		},
+		cli.StringFlag{
+			Name:        "mfa-serial",
+			EnvVar:      "AWS_ENV_MFA_SERIAL",
+			Usage:       "with --assume-role, the MFA device used to assume the first role",
+			Destination: &mfaSerial,
+		},
+		cli.StringFlag{
+			Name:        "mfa-token",
+			EnvVar:      "AWS_ENV_MFA_TOKEN",
+			Usage:       "with --mfa-serial, the current MFA token",
+			Destination: &mfaToken,
+		},
+		cli.BoolFlag{
+			Name:        "mfa-token-stdin",
+			EnvVar:      "AWS_ENV_MFA_TOKEN_STDIN",
+			Usage:       "with --mfa-serial, read the MFA token from the first line of stdin",
+			Destination: &mfaTokenStdin,
+		},
+		cli.StringFlag{
+			Name:        "endpoint-url",
+			EnvVar:      "AWS_ENV_ENDPOINT_URL",
+			Usage:       "parameter store and secrets manager endpoint for --region, e.g. a local emulator",
+			Destination: &endpointURL,
+		},
+		cli.StringFlag{
+			Name:        "sts-endpoint-url",
+			EnvVar:      "AWS_ENV_STS_ENDPOINT_URL",
+			Usage:       "STS endpoint used to assume roles",
+			Destination: &stsEndpointURL,
+		},
+		cli.BoolFlag{
+			Name:        "use-fips-endpoint",
+			EnvVar:      "AWS_ENV_USE_FIPS_ENDPOINT",
+			Usage:       "use FIPS endpoints",
+			Destination: &useFIPSEndpoint,
+		},
+		cli.BoolFlag{
+			Name:        "use-dualstack-endpoint",
+			EnvVar:      "AWS_ENV_USE_DUALSTACK_ENDPOINT",
+			Usage:       "use dual-stack (IPv4 and IPv6) endpoints",
+			Destination: &useDualStackEndpoint,
+		},
+		cli.StringSliceFlag{
+			Name:   "account-role",
+			EnvVar: "AWS_ENV_ACCOUNT_ROLES",
+			Usage:  "role to assume for parameter ARNs in an account, as ACCOUNT_ID=ROLE_ARN (may be repeated)",
+			Value:  &accountRoles,
+		},
+		cli.StringFlag{
+			Name:        "base-path",
+			EnvVar:      "AWS_ENV_BASE_PATH",
+			Usage:       "path that relative references (not beginning with /) are resolved against",
+			Destination: &basePath,
+		},
+		cli.StringFlag{
+			Name:        "search",
+			EnvVar:      "AWS_ENV_SEARCH",
+			Usage:       "comma-separated paths that relative references are looked up under, using the first where the parameter exists",
+			Destination: &searchPaths,
+		},
+		cli.BoolFlag{
+			Name:        "verbose",
+			EnvVar:      "AWS_ENV_VERBOSE",
+			Usage:       "log in more detail, including which --search path each value came from",
+			Destination: &verbose,
+		},
+		cli.StringSliceFlag{
+			Name:   "var",
+			EnvVar: "AWS_ENV_VARS",
+			Usage:  "value for a ${NAME} placeholder in reference paths, as NAME=VALUE (may be repeated); other placeholders are taken from the environment",
+			Value:  &placeholderVars,
+		},
 		cli.StringFlag{
 			Name:        "file, f",
 			Usage:       "file to be updated by aws-env with Parameter Store values",
@@ -90,9 +259,86 @@ func initApp() *cli.App {
 		cli.BoolFlag{
 			Name:        "ecs",
 			EnvVar:      "AWS_ENV_ECS",
-			Usage:       "enable ECS mode, using the default credential provider to support ECS",
+			Usage:       "deprecated: container credentials are now always used when available",
 			Destination: &ecs,
 		},
+		cli.StringFlag{
+			Name:        "source",
+			EnvVar:      "AWS_ENV_SOURCE",
+			Usage:       "where unqualified values are retrieved from: ssm (parameter store) or secretsmanager",
+			Value:       sourceSSM,
+			Destination: &source,
+		},
+		cli.StringFlag{
+			Name:        "expand-prefix",
+			EnvVar:      "AWS_ENV_EXPAND_PREFIX",
+			Usage:       "prefix shared by values whose parameter holds many variables as JSON or dotenv",
+			Value:       awsenv.DefaultExpandPrefix,
+			Destination: &expandPrefix,
+		},
+		cli.BoolFlag{
+			Name:        "expand-override",
+			EnvVar:      "AWS_ENV_EXPAND_OVERRIDE",
+			Usage:       "let expanded variables overwrite variables that are already set",
+			Destination: &expandOverride,
+		},
+		cli.IntFlag{
+			Name:        "max-depth",
+			EnvVar:      "AWS_ENV_MAX_DEPTH",
+			Usage:       "how many references deep to follow references found inside parameter values (0 to disable)",
+			Value:       awsenv.DefaultMaxDepth,
+			Destination: &maxDepth,
+		},
+		cli.StringFlag{
+			Name:        "path",
+			EnvVar:      "AWS_ENV_PATH",
+			Usage:       "export every parameter under this parameter store path as a variable",
+			Destination: &importPath,
+		},
+		cli.BoolFlag{
+			Name:        "recursive",
+			EnvVar:      "AWS_ENV_RECURSIVE",
+			Usage:       "with --path, also export parameters nested deeper below the path",
+			Destination: &recursive,
+		},
+		cli.StringFlag{
+			Name:        "path-naming",
+			EnvVar:      "AWS_ENV_PATH_NAMING",
+			Usage:       "with --path, how variable names are derived: last (last path segment) or relative (path relative to --path)",
+			Value:       "last",
+			Destination: &pathNaming,
+		},
+		cli.StringFlag{
+			Name:        "config",
+			EnvVar:      "AWS_ENV_CONFIG",
+			Usage:       "YAML file mapping env var names to parameters, resolved along with prefixed env vars",
+			Destination: &configFile,
+		},
+		cli.StringFlag{
+			Name:        "format",
+			EnvVar:      "AWS_ENV_FORMAT",
+			Usage:       "output format when no command is given: " + formatNames(),
+			Value:       "bash",
+			Destination: &outputFormat,
+		},
+		cli.BoolFlag{
+			Name:        "full-env",
+			EnvVar:      "AWS_ENV_FULL_ENV",
+			Usage:       "when no command is given, output the entire environment rather than only the replaced variables",
+			Destination: &fullEnv,
+		},
+		cli.StringFlag{
+			Name:        "secret-files",
+			EnvVar:      "AWS_ENV_SECRET_FILES",
+			Usage:       "write resolved values to files for the command instead of exporting them: suffix (set NAME_FILE to the path) or replace (set NAME to the path)",
+			Destination: &secretFiles,
+		},
+		cli.StringFlag{
+			Name:        "secret-files-dir",
+			EnvVar:      "AWS_ENV_SECRET_FILES_DIR",
+			Usage:       "with --secret-files, where the files are written (default /dev/shm if present, otherwise the temp dir)",
+			Destination: &secretFilesDir,
+		},
 	}
 	newApp.Commands = append(newApp.Commands, cli.Command{
 		Name:   "licenses",
@@ -104,116 +350,406 @@ func initApp() *cli.App {
 }
 
 func run(c *cli.Context) error {
+	if verbose {
+		log.SetLevel(log.DebugLevel)
+	}
+
 	log.WithFields(log.Fields{
 		"app_version": version,
 		"git_hash":    gitHash,
 		"built_at":    builtAt,
 	}).Info("aws-env starting")
 
-	// First try the ec2 metadata service (kube2iam)
-	// Then try the environment variables
-	creds := credentials.NewChainCredentials(
-		[]credentials.Provider{
-			&ec2rolecreds.EC2RoleProvider{
-				Client: ec2metadata.New(session.Must(session.NewSession())),
-			},
-			&credentials.EnvProvider{},
-		})
-	// Unless profile is specified, then that wins priority
-	if profile != "" {
-		creds = credentials.NewSharedCredentials("", profile)
-	}
-	// Unless ECS is specified, then use the default credentials
 	if ecs {
-		creds = defaults.Get().Config.Credentials
+		log.Warn("--ecs is deprecated and has no effect, container credentials are always used when available")
 	}
 
-	// If assumeRole is specified, then call sts and get further assume creds
-	if assumeRole != "" {
-		awsCfg := aws.NewConfig().WithRegion(region).WithCredentials(creds)
-		sess := session.Must(session.NewSession(awsCfg))
-		stsClient := sts.New(sess)
+	cfg, err := loadAWSConfig(context.Background(), c.Args().First())
+	if err != nil {
+		return err
+	}
 
-		assumeRoleInput := &sts.AssumeRoleInput{
-			RoleArn:         aws.String(assumeRole),
-			RoleSessionName: aws.String("awsenv_assume_role_session"),
-		}
+	getter, err := newParamsGetter(cfg)
+	if err != nil {
+		return err
+	}
 
-		assumeRoleOutput, err := stsClient.AssumeRole(assumeRoleInput)
+	opts, err := resolveOptions()
+	if err != nil {
+		return err
+	}
+
+	if fileName != "" {
+		return fileReplacement(getter, opts)
+	}
+
+	var importer *awsenv.PathImporter
+	if importPath != "" {
+		importer, err = newPathImporter(v2.NewPathGetter(ssm.NewFromConfig(cfg, withSSMEndpoint)))
 		if err != nil {
-			log.WithFields(log.Fields{
-				"assume_role": assumeRole,
-			}).Error("unable to assume role")
 			return err
 		}
+	}
+
+	return envReplacement(c, getter, importer, opts)
+}
+
+// resolveOptions returns the options shared by env and file replacement.
+func resolveOptions() ([]awsenv.Option, error) {
+	vars, err := parseVars(placeholderVars)
+	if err != nil {
+		return nil, err
+	}
 
-		creds = credentials.NewStaticCredentials(
-			aws.StringValue(assumeRoleOutput.Credentials.AccessKeyId),
-			aws.StringValue(assumeRoleOutput.Credentials.SecretAccessKey),
-			aws.StringValue(assumeRoleOutput.Credentials.SessionToken),
-		)
+	search, err := parseSearchPaths()
+	if err != nil {
+		return nil, err
 	}
 
-	awsCfg := aws.NewConfig().WithRegion(region).WithCredentials(creds)
-	sess := session.Must(session.NewSession(awsCfg))
-	ssmClient := ssm.New(sess)
+	return []awsenv.Option{
+		awsenv.WithMaxDepth(maxDepth),
+		awsenv.WithVars(vars),
+		awsenv.WithSearchPaths(search...),
+		awsenv.WithSearchHook(logSearch),
+	}, nil
+}
 
-	if fileName != "" {
-		return fileReplacement(ssmClient)
+// newPathImporter returns an awsenv.PathImporter configured by the --path,
+// --recursive and --path-naming flags.
+func newPathImporter(getter awsenv.PathGetter) (*awsenv.PathImporter, error) {
+	mapper, ok := pathNamings[pathNaming]
+	if !ok {
+		return nil, fmt.Errorf("unknown path naming %q, must be one of: last, relative", pathNaming)
 	}
 
-	return envReplacement(c, ssmClient)
+	return awsenv.NewPathImporter(importPath, getter,
+		awsenv.WithRecursive(recursive),
+		awsenv.WithNameMapper(mapper),
+		awsenv.WithExpandOverride(expandOverride),
+	), nil
 }
 
-func envReplacement(c *cli.Context, ssmClient *ssm.SSM) error {
-	r := awsenv.NewReplacer(prefix, v1.NewParamsGetter(ssmClient))
+// newParamsGetter returns an awsenv.ParamsGetter that resolves references
+// prefixed with a scheme ("ssm:" or "sm:") from the matching backend, and
+// all other references from the backend selected by the --source flag.
+// Parameter ARNs are resolved in the region and account they name.
+func newParamsGetter(cfg aws.Config) (awsenv.ParamsGetter, error) {
+	roles, err := parseAccountRoles(accountRoles)
+	if err != nil {
+		return nil, err
+	}
+
+	ssmGetter := awsenv.NewARNRouter(v2.NewParamsGetter(ssm.NewFromConfig(cfg, withSSMEndpoint)), newRegionalGetter(cfg, roles))
+	smGetter := v2.NewSecretsGetter(secretsmanager.NewFromConfig(cfg, withSecretsManagerEndpoint))
+
+	var fallback awsenv.ParamsGetter
+	switch source {
+	case sourceSSM:
+		fallback = ssmGetter
+	case sourceSecretsManager:
+		fallback = smGetter
+	default:
+		return nil, fmt.Errorf("unknown source %q, must be one of: %s, %s", source, sourceSSM, sourceSecretsManager)
+	}
+
+	router := awsenv.NewRouter(fallback)
+	router.Register(schemeSSM, ssmGetter)
+	router.Register(schemeSecretsManager, smGetter)
+
+	return router, nil
+}
+
+// newRegionalGetter returns an awsenv.GetterFactory creating Parameter Store
+// clients for other partitions, regions and accounts. The SDK resolves the
+// endpoint for the region, which must belong to the partition named in the
+// ARN. Clients for an account listed in roles assume its role first, using
+// STS in the same region so that roles outside the aws partition work.
+func newRegionalGetter(cfg aws.Config, roles map[string]string) awsenv.GetterFactory {
+	return func(partition, region, account string) (awsenv.ParamsGetter, error) {
+		if p := awsenv.RegionPartition(region); p != partition {
+			return nil, fmt.Errorf("region %s is in partition %s, not %s", region, p, partition)
+		}
+
+		regionalCfg := cfg.Copy()
+		regionalCfg.Region = region
+
+		if role, ok := roles[account]; ok {
+			log.WithFields(log.Fields{
+				"account":     account,
+				"assume_role": role,
+			}).Info("assuming role for account")
+			regionalCfg = v2.AssumeRole(regionalCfg, role, stsOptions())
+		}
+
+		// --endpoint-url is only for the region it is in
+		var optFns []func(*ssm.Options)
+		if region == cfg.Region {
+			optFns = append(optFns, withSSMEndpoint)
+		}
+
+		return v2.NewParamsGetter(ssm.NewFromConfig(regionalCfg, optFns...)), nil
+	}
+}
+
+// parseAccountRoles parses the ACCOUNT_ID=ROLE_ARN values of the
+// --account-role flag.
+func parseAccountRoles(vals []string) (map[string]string, error) {
+	roles := make(map[string]string, len(vals))
+	for _, val := range vals {
+		account, role, ok := strings.Cut(val, "=")
+		if !ok || account == "" || role == "" {
+			return nil, fmt.Errorf("invalid account role %q, must be ACCOUNT_ID=ROLE_ARN", val)
+		}
+		roles[account] = role
+	}
+
+	return roles, nil
+}
+
+// parseSearchPaths returns the paths relative references are looked up
+// under, given by either the --base-path or the --search flag. They are
+// Parameter Store paths, so the --source flag must be ssm.
+func parseSearchPaths() ([]string, error) {
+	if basePath != "" && searchPaths != "" {
+		return nil, errors.New("--base-path and --search cannot be used together")
+	}
+	if (basePath != "" || searchPaths != "") && source != sourceSSM {
+		return nil, fmt.Errorf("--base-path and --search require --source %s", sourceSSM)
+	}
+
+	var paths []string
+	if basePath != "" {
+		paths = []string{basePath}
+	}
+	if searchPaths != "" {
+		paths = strings.Split(searchPaths, ",")
+	}
+
+	for i, path := range paths {
+		path = strings.TrimSpace(path)
+		if !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("invalid search path %q, must begin with /", path)
+		}
+		paths[i] = path
+	}
+
+	return paths, nil
+}
+
+// logSearch logs which --search path a value came from.
+func logSearch(source, path string) {
+	log.WithFields(log.Fields{
+		"source": source,
+		"param":  path,
+	}).Debug("found in search path")
+}
+
+// parseVars parses the NAME=VALUE values of the --var flag.
+func parseVars(vals []string) (map[string]string, error) {
+	vars := make(map[string]string, len(vals))
+	for _, val := range vals {
+		name, value, ok := strings.Cut(val, "=")
+		if !ok || !validName.MatchString(name) {
+			return nil, fmt.Errorf("invalid var %q, must be NAME=VALUE", val)
+		}
+		vars[name] = value
+	}
+
+	return vars, nil
+}
+
+func envReplacement(c *cli.Context, getter awsenv.ParamsGetter, importer *awsenv.PathImporter, opts []awsenv.Option) error {
+	var mapping map[string]string
+	if configFile != "" {
+		var err error
+		mapping, err = awsenv.ReadMapping(configFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	r := awsenv.NewReplacer(prefix, getter, append(opts,
+		awsenv.WithExpandPrefix(expandPrefix),
+		awsenv.WithExpandOverride(expandOverride),
+		awsenv.WithMapping(mapping),
+	)...)
 
 	if c.NArg() == 0 {
-		return dump(r)
+		if secretFiles != "" {
+			return errors.New("--secret-files requires a command to run")
+		}
+		return dump(r, importer)
 	}
 
 	args := c.Args()
-	return invoke(r, args.First(), args.Tail())
+	return invoke(r, importer, args.First(), args.Tail())
 }
 
-func fileReplacement(ssmClient *ssm.SSM) error {
-	r := awsenv.NewFileReplacer(prefix, fileName, v1.NewParamsGetter(ssmClient))
+func fileReplacement(getter awsenv.ParamsGetter, opts []awsenv.Option) error {
+	r := awsenv.NewFileReplacer(prefix, fileName, getter, opts...)
 
 	ctx := context.Background()
-	return r.ReplaceAll(ctx)
+	err := r.ReplaceAll(ctx)
+	logResolveError(err)
+	return err
 }
 
-func dump(r *awsenv.Replacer) error {
-	ctx := context.Background()
+// logResolveError logs every reference that could not be resolved, if err
+// is an *awsenv.ResolveError.
+func logResolveError(err error) {
+	var rerr *awsenv.ResolveError
+	if !errors.As(err, &rerr) {
+		return
+	}
+
+	for _, perr := range rerr.Errors {
+		log.WithFields(log.Fields{
+			"source": perr.Source,
+			"param":  perr.Name,
+		}).WithError(perr.Err).Error("unable to resolve reference")
+	}
+}
+
+func dump(r *awsenv.Replacer, importer *awsenv.PathImporter) error {
+	format, ok := formats[outputFormat]
+	if !ok {
+		return fmt.Errorf("unknown format %q, must be one of: %s", outputFormat, formatNames())
+	}
 
-	vars, err := r.Replacements(ctx)
+	vars, err := replacements(context.Background(), r, importer, fullEnv)
 	if err != nil {
 		return err
 	}
 
 	if len(vars) == 0 {
 		log.Info("nothing to replace")
-		return nil
 	}
 
-	for name, newVal := range vars {
+	for _, name := range sortedNames(vars) {
+		if !validName.MatchString(name) {
+			log.WithField("envvar", name).Warn("skipping variable whose name cannot be exported")
+			delete(vars, name)
+			continue
+		}
 		log.WithField("envvar", name).Info("replacing")
-		fmt.Printf("export %s=$'%s'\n", name, newVal)
 	}
 
-	return nil
+	return format(os.Stdout, vars)
 }
 
-func invoke(r *awsenv.Replacer, prog string, args []string) error {
-	ctx := context.Background()
+// replacements returns the env vars resolved by r, along with those imported
+// by importer, if any. If full is set, the rest of the environment is
+// included too.
+func replacements(ctx context.Context, r *awsenv.Replacer, importer *awsenv.PathImporter, full bool) (map[string]string, error) {
+	replace := r.Resolved
+	if full {
+		replace = r.Replacements
+	}
 
-	err := r.ReplaceAll(ctx)
+	vars, err := replace(ctx)
 	if err != nil {
-		log.WithError(err).Error("failed to replace env vars")
-		return err
+		logResolveError(err)
+		return nil, err
+	}
+
+	if importer != nil {
+		imported, err := importer.Replacements(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if vars == nil {
+			vars = make(map[string]string, len(imported))
+		}
+		for name, val := range imported {
+			vars[name] = val
+		}
+	}
+
+	return vars, nil
+}
+
+// secretFilesEnv writes the resolved values to files, and returns the
+// environment for the child process, which refers to them in the way
+// selected by the --secret-files flag.
+func secretFilesEnv(ctx context.Context, r *awsenv.Replacer, importer *awsenv.PathImporter) ([]string, *awsenv.SecretFiles, error) {
+	if secretFiles != secretFilesSuffix && secretFiles != secretFilesReplace {
+		return nil, nil, fmt.Errorf("unknown secret files mode %q, must be one of: %s, %s", secretFiles, secretFilesSuffix, secretFilesReplace)
+	}
+
+	vars, err := replacements(ctx, r, importer, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	files, err := awsenv.WriteSecretFiles(secretFilesDir, vars)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	env := make([]string, 0, len(os.Environ())+len(vars))
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		if _, ok := vars[name]; ok {
+			continue
+		}
+		env = append(env, kv)
+	}
+
+	for name, path := range files.Paths {
+		log.WithFields(log.Fields{
+			"envvar": name,
+			"file":   path,
+		}).Info("writing secret file")
+
+		if secretFiles == secretFilesSuffix {
+			name += "_FILE"
+		}
+		env = append(env, name+"="+path)
+	}
+
+	return env, files, nil
+}
+
+func invoke(r *awsenv.Replacer, importer *awsenv.PathImporter, prog string, args []string) error {
+	ctx := context.Background()
+
+	// nil inherits the environment of this process
+	var env []string
+
+	if secretFiles != "" {
+		var files *awsenv.SecretFiles
+		var err error
+		env, files, err = secretFilesEnv(ctx, r, importer)
+		if err != nil {
+			log.WithError(err).Error("failed to write secret files")
+			return err
+		}
+
+		// the files are removed once the command exits
+		defer func() {
+			if err := files.Remove(); err != nil {
+				log.WithError(err).WithField("dir", files.Dir).Error("failed to remove secret files")
+			}
+		}()
+	} else {
+		err := r.ReplaceAll(ctx)
+		if err != nil {
+			logResolveError(err)
+			log.WithError(err).Error("failed to replace env vars")
+			return err
+		}
+
+		if importer != nil {
+			err = importer.ReplaceAll(ctx)
+			if err != nil {
+				log.WithError(err).Error("failed to import env vars from path")
+				return err
+			}
+		}
 	}
 
 	cmd := exec.Command(prog, args...) // nolint: gosec
+	cmd.Env = env
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
@@ -221,7 +757,7 @@ func invoke(r *awsenv.Replacer, prog string, args []string) error {
 	// in order to make sure that we catch and propagate signals correctly, we need
 	// to decouple starting the command and waiting for it to complete, so we can
 	// send signals as it runs
-	err = cmd.Start()
+	err := cmd.Start()
 	if err != nil {
 		log.WithError(err).Error("failed to start child process")
 		return err
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sendgrid/aws-env/awsenv"
+)
+
+func TestParseSearchPaths(t *testing.T) {
+	tests := []struct {
+		name    string
+		base    string
+		search  string
+		source  string
+		want    []string
+		wantErr string
+	}{
+		{
+			name:   "none",
+			source: sourceSSM,
+		},
+		{
+			name:   "base_path",
+			base:   "/prod/my-app",
+			source: sourceSSM,
+			want:   []string{"/prod/my-app"},
+		},
+		{
+			name:   "search",
+			search: "/prod/my-app, /global",
+			source: sourceSSM,
+			want:   []string{"/prod/my-app", "/global"},
+		},
+		{
+			name:    "both",
+			base:    "/prod/my-app",
+			search:  "/global",
+			source:  sourceSSM,
+			wantErr: "--base-path and --search cannot be used together",
+		},
+		{
+			name:    "not_absolute",
+			search:  "/prod/my-app,global",
+			source:  sourceSSM,
+			wantErr: `invalid search path "global", must begin with /`,
+		},
+		{
+			name:    "base_path_secrets_manager",
+			base:    "/prod/my-app",
+			source:  sourceSecretsManager,
+			wantErr: "--base-path and --search require --source ssm",
+		},
+		{
+			name:    "search_secrets_manager",
+			search:  "/prod/my-app,/global",
+			source:  sourceSecretsManager,
+			wantErr: "--base-path and --search require --source ssm",
+		},
+		{
+			name:   "none_secrets_manager",
+			source: sourceSecretsManager,
+		},
+	}
+
+	origBase, origSearch, origSource := basePath, searchPaths, source
+	t.Cleanup(func() { basePath, searchPaths, source = origBase, origSearch, origSource })
+
+	for _, test := range tests {
+		basePath, searchPaths, source = test.base, test.search, test.source
+		got, err := parseSearchPaths()
+		if test.wantErr != "" {
+			require.EqualError(t, err, test.wantErr, test.name)
+			continue
+		}
+		require.NoError(t, err, test.name)
+		require.Equal(t, test.want, got, test.name)
+	}
+}
+
+// staticGetter is an awsenv.ParamsGetter returning fixed values.
+type staticGetter map[string]string
+
+func (g staticGetter) GetParams(_ context.Context, names []string) (map[string]string, error) {
+	vals := make(map[string]string, len(names))
+	for _, name := range names {
+		if val, ok := g[name]; ok {
+			vals[name] = val
+		}
+	}
+	return vals, nil
+}
+
+// setSecretFiles sets the --secret-files flags for the duration of a test.
+func setSecretFiles(t *testing.T, mode string) string {
+	t.Helper()
+	origMode, origDir := secretFiles, secretFilesDir
+	t.Cleanup(func() { secretFiles, secretFilesDir = origMode, origDir })
+	secretFiles, secretFilesDir = mode, t.TempDir()
+	return secretFilesDir
+}
+
+// parseEnv parses the output of env.
+func parseEnv(t *testing.T, fileName string) map[string]string {
+	t.Helper()
+	b, err := ioutil.ReadFile(fileName)
+	require.NoError(t, err)
+
+	env := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		name, val, _ := strings.Cut(line, "=")
+		env[name] = val
+	}
+	return env
+}
+
+func TestSecretFilesEnv(t *testing.T) {
+	tests := []struct {
+		mode    string
+		varName string
+		gone    []string
+	}{
+		{mode: secretFilesSuffix, varName: "DB_PASS_FILE", gone: []string{"DB_PASS"}},
+		{mode: secretFilesReplace, varName: "DB_PASS"},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.mode, func(t *testing.T) {
+			dir := setSecretFiles(t, test.mode)
+			t.Setenv("DB_PASS", "awsenv:/prod/db/pass")
+			t.Setenv("PLAIN", "unchanged")
+
+			r := awsenv.NewReplacer(awsenv.DefaultPrefix, staticGetter{"/prod/db/pass": "s3cr3t"})
+			env, files, err := secretFilesEnv(context.Background(), r, nil)
+			require.NoError(t, err)
+			t.Cleanup(func() { require.NoError(t, files.Remove()) })
+
+			path := files.Paths["DB_PASS"]
+			require.Equal(t, dir, filepath.Dir(files.Dir))
+			require.Equal(t, files.Dir, filepath.Dir(path))
+			require.Contains(t, env, test.varName+"="+path)
+			require.Contains(t, env, "PLAIN=unchanged")
+			require.NotContains(t, env, "DB_PASS=awsenv:/prod/db/pass")
+			for _, name := range test.gone {
+				for _, kv := range env {
+					require.False(t, strings.HasPrefix(kv, name+"="), kv)
+				}
+			}
+
+			b, err := ioutil.ReadFile(path)
+			require.NoError(t, err)
+			require.Equal(t, "s3cr3t", string(b))
+
+			info, err := os.Stat(path)
+			require.NoError(t, err)
+			require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+			info, err = os.Stat(files.Dir)
+			require.NoError(t, err)
+			require.Equal(t, os.FileMode(0700), info.Mode().Perm())
+		})
+	}
+}
+
+func TestSecretFilesEnv_unknownMode(t *testing.T) {
+	setSecretFiles(t, "symlink")
+	r := awsenv.NewReplacer(awsenv.DefaultPrefix, staticGetter{})
+	_, _, err := secretFilesEnv(context.Background(), r, nil)
+	require.EqualError(t, err, `unknown secret files mode "symlink", must be one of: suffix, replace`)
+}
+
+func TestInvoke_secretFiles(t *testing.T) {
+	tests := []struct {
+		name     string
+		exitCode string
+		wantErr  string
+	}{
+		{
+			name:     "success",
+			exitCode: "0",
+		},
+		{
+			name:     "failure",
+			exitCode: "3",
+			wantErr:  "exit status 3",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			dir := setSecretFiles(t, secretFilesSuffix)
+			t.Setenv("DB_PASS", "awsenv:/prod/db/pass")
+			t.Setenv("PLAIN", "unchanged")
+			out := filepath.Join(t.TempDir(), "env")
+
+			r := awsenv.NewReplacer(awsenv.DefaultPrefix, staticGetter{"/prod/db/pass": "s3cr3t"})
+			// the child records its environment and the secret it was given
+			err := invoke(r, nil, "sh", []string{"-c", `env > "$0" && cat "$DB_PASS_FILE" > "$0.secret"; exit "$1"`, out, test.exitCode})
+			if test.wantErr != "" {
+				require.EqualError(t, err, test.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+
+			env := parseEnv(t, out)
+			require.Equal(t, "unchanged", env["PLAIN"])
+			require.NotContains(t, env, "DB_PASS")
+			path := env["DB_PASS_FILE"]
+			require.Equal(t, dir, filepath.Dir(filepath.Dir(path)))
+
+			b, err := ioutil.ReadFile(out + ".secret")
+			require.NoError(t, err)
+			require.Equal(t, "s3cr3t", string(b))
+
+			// the files are removed once the child exits
+			_, err = os.Stat(filepath.Dir(path))
+			require.True(t, os.IsNotExist(err), "%v", err)
+			entries, err := ioutil.ReadDir(dir)
+			require.NoError(t, err)
+			require.Empty(t, entries)
+		})
+	}
+}
@@ -4,7 +4,31 @@ set -e
 export GO111MODULE=on
 make build
 
-aws --region us-east-1 ssm put-parameter --name /some/test/key --value "my-secret-value" --type SecureString --key-id "alias/aws/ssm" --overwrite
+region=us-east-1
+aws_args=(--region "$region")
+aws_env_args=(--region "$region")
+
+# Set AWS_ENV_ENDPOINT_URL to run against a local emulator such as LocalStack
+# (e.g. http://localhost:4566) instead of a real account. Emulators accept any
+# credentials, so dummy ones are used unless some are set.
+if [ -n "$AWS_ENV_ENDPOINT_URL" ]; then
+  export AWS_ACCESS_KEY_ID="${AWS_ACCESS_KEY_ID:-test}"
+  export AWS_SECRET_ACCESS_KEY="${AWS_SECRET_ACCESS_KEY:-test}"
+  aws_args+=(--endpoint-url "$AWS_ENV_ENDPOINT_URL")
+  aws_env_args+=(--endpoint-url "$AWS_ENV_ENDPOINT_URL")
+fi
+
+aws "${aws_args[@]}" ssm put-parameter --name /some/test/key --value "my-secret-value" --type SecureString --key-id "alias/aws/ssm" --overwrite
+aws "${aws_args[@]}" secretsmanager create-secret --name some/test/secret --secret-string "my-secret-value" >/dev/null 2>&1 ||
+  aws "${aws_args[@]}" secretsmanager put-secret-value --secret-id some/test/secret --secret-string "my-secret-value"
+
+# expect fails the test unless $2 is the value $1 should have been set to
+expect() {
+  if [ "$2" != "my-secret-value" ]; then
+    echo "FAIL: $1 is '$2'"
+    exit 1
+  fi
+}
 
 # Test eval invocation
 echo "Eval Invocation:"
@@ -12,9 +36,11 @@ export AWS_ENV_TEST_KEY_EVAL='awsenv:/some/test/key'
 export AWS_ENV_TEST_KEY_EVAL2='awsenv:/some/test/key'
 echo "before: AWS_ENV_TEST_KEY_EVAL=$AWS_ENV_TEST_KEY_EVAL"
 echo "before: AWS_ENV_TEST_KEY_EVAL2=$AWS_ENV_TEST_KEY_EVAL2"
-eval $(./aws-env)
+eval "$(./aws-env "${aws_env_args[@]}")"
 echo "after: AWS_ENV_TEST_KEY_EVAL=$AWS_ENV_TEST_KEY_EVAL"
 echo "after: AWS_ENV_TEST_KEY_EVAL2=$AWS_ENV_TEST_KEY_EVAL2"
+expect AWS_ENV_TEST_KEY_EVAL "$AWS_ENV_TEST_KEY_EVAL"
+expect AWS_ENV_TEST_KEY_EVAL2 "$AWS_ENV_TEST_KEY_EVAL2"
 
 echo ""
 
@@ -22,5 +48,16 @@ echo ""
 echo "Command Invocation:"
 export AWS_ENV_TEST_KEY_CMD='awsenv:/some/test/key'
 echo "before: AWS_ENV_TEST_KEY_CMD=$AWS_ENV_TEST_KEY_CMD"
-echo "after: $(./aws-env env | grep AWS_ENV_TEST_KEY_CMD)"
+after=$(./aws-env "${aws_env_args[@]}" env | grep '^AWS_ENV_TEST_KEY_CMD=')
+echo "after: $after"
+expect AWS_ENV_TEST_KEY_CMD "${after#AWS_ENV_TEST_KEY_CMD=}"
+
+echo ""
 
+# Test a Secrets Manager reference
+echo "Secrets Manager:"
+export AWS_ENV_TEST_KEY_SM='awsenv:sm:some/test/secret'
+echo "before: AWS_ENV_TEST_KEY_SM=$AWS_ENV_TEST_KEY_SM"
+after=$(./aws-env "${aws_env_args[@]}" env | grep '^AWS_ENV_TEST_KEY_SM=')
+echo "after: $after"
+expect AWS_ENV_TEST_KEY_SM "${after#AWS_ENV_TEST_KEY_SM=}"